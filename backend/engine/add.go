@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// AddOptions are applied to a torrent as it is added, before any of
+// its pieces are requested
+type AddOptions struct {
+	Stream        bool   //data kept in memory, see NewStream
+	Paused        bool   //don't start, even with AutoStart
+	SavePath      string //inside the download directory
+	Category      string //also the save path, when none is given
+	Tags          []string
+	Files         []int    //indexes of the files to download
+	FileGlobs     []string //patterns matching the paths of files to download
+	Sequential    bool     //download pieces in order
+	DownloadLimit int      //bytes per second, 0 is unlimited
+	UploadLimit   int      //bytes per second, 0 is unlimited
+}
+
+// wants is true when the file at index i is selected for download
+func (o *AddOptions) wants(i int, p string) bool {
+	if o == nil || (len(o.Files) == 0 && len(o.FileGlobs) == 0) {
+		return true
+	}
+	for _, index := range o.Files {
+		if index == i {
+			return true
+		}
+	}
+	//patterns match the path, the path within the torrent folder
+	//or just the file name
+	names := []string{p, path.Base(p)}
+	if slash := strings.Index(p, "/"); slash >= 0 {
+		names = append(names, p[slash+1:])
+	}
+	for _, g := range o.FileGlobs {
+		for _, name := range names {
+			if ok, _ := path.Match(g, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkOptions validates and normalises the options, returning the
+// directory the torrent is saved in, empty for the download directory
+func (e *Engine) checkOptions(o *AddOptions) (string, error) {
+	for _, i := range o.Files {
+		if i < 0 {
+			return "", fmt.Errorf("Invalid file index (%d)", i)
+		}
+	}
+	for _, g := range o.FileGlobs {
+		if _, err := path.Match(g, ""); err != nil {
+			return "", fmt.Errorf("Invalid file pattern (%s)", g)
+		}
+	}
+	if o.DownloadLimit < 0 || o.UploadLimit < 0 {
+		return "", fmt.Errorf("Invalid rate limit")
+	}
+	tags := []string{}
+	for _, tag := range o.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	o.Tags = tags
+	o.Category = strings.TrimSpace(o.Category)
+	dir := o.SavePath
+	if dir == "" {
+		dir = o.Category
+	}
+	if dir == "" {
+		return "", nil
+	}
+	if o.Stream && o.SavePath != "" {
+		return "", fmt.Errorf("Stream torrents are kept in memory")
+	} else if o.Stream {
+		return "", nil
+	}
+	if e.config.Storage == StorageMMap {
+		return "", fmt.Errorf("Save paths are not supported with mmap storage")
+	}
+	base := filepath.Clean(e.config.DownloadDirectory)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(base, dir)
+	}
+	dir = filepath.Clean(dir)
+	if dir != base && !strings.HasPrefix(dir, base+string(filepath.Separator)) {
+		return "", fmt.Errorf("Save path must be inside the download directory")
+	}
+	if dir == base {
+		return "", nil
+	}
+	return dir, nil
+}
+
+// checkFiles rejects file indexes past the files of the torrent
+func checkFiles(o *AddOptions, files int) error {
+	for _, i := range o.Files {
+		if i >= files {
+			return fmt.Errorf("Invalid file index (%d), the torrent has %d files", i, files)
+		}
+	}
+	return nil
+}
+
+// Add adds a torrent with the given options
+func (e *Engine) Add(spec *torrent.TorrentSpec, opts AddOptions) error {
+	dir, err := e.checkOptions(&opts)
+	if err != nil {
+		return err
+	}
+	//magnets are checked once their info is known, see added
+	if len(spec.InfoBytes) > 0 && len(opts.Files) > 0 {
+		var info metainfo.Info
+		if err := bencode.Unmarshal(spec.InfoBytes, &info); err != nil {
+			return fmt.Errorf("Invalid torrent info: %s", err)
+		}
+		if err := checkFiles(&opts, len(info.UpvertedFiles())); err != nil {
+			return err
+		}
+	}
+	if opts.Stream {
+		spec.Storage = e.stream
+	}
+	inner := spec.Storage
+	if inner == nil {
+		inner = e.storage
+	}
+	limits := newLimitStorage(inner, opts.DownloadLimit, opts.UploadLimit)
+	spec.Storage = limits
+	//files are selected as they are loaded, which may be while adding
+	ih := spec.InfoHash.HexString()
+	t := &Torrent{
+		InfoHash:      ih,
+		AddedAt:       time.Now(),
+		Stream:        opts.Stream,
+		SavePath:      dir,
+		Category:      opts.Category,
+		Tags:          opts.Tags,
+		Sequential:    opts.Sequential,
+		DownloadLimit: opts.DownloadLimit,
+		UploadLimit:   opts.UploadLimit,
+		options:       &opts,
+		storage:       limits,
+	}
+	e.mut.Lock()
+	client := e.client
+	if client == nil {
+		e.mut.Unlock()
+		return fmt.Errorf("Engine not configured")
+	}
+	if _, ok := e.ts[ih]; ok {
+		e.mut.Unlock()
+		return conflict("Torrent already added")
+	}
+	if _, ok := client.Torrent(spec.InfoHash); ok {
+		e.mut.Unlock()
+		return conflict("Torrent already added")
+	}
+	e.ts[ih] = t
+	if dir != "" {
+		e.savePaths.Store(spec.InfoHash, dir)
+	}
+	e.uploads.Store(spec.InfoHash, limits)
+	e.mut.Unlock()
+	tt, _, err := client.AddTorrentSpec(spec)
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if err != nil {
+		delete(e.ts, ih)
+		e.savePaths.Delete(spec.InfoHash)
+		e.uploads.Delete(spec.InfoHash)
+		return err
+	}
+	e.upsertTorrent(tt)
+	go e.added(t, tt)
+	return nil
+}
+
+// AddMagnet adds a magnet with the given options
+func (e *Engine) AddMagnet(magnetURI string, opts AddOptions) error {
+	spec, err := torrent.TorrentSpecFromMagnetUri(magnetURI)
+	if err != nil {
+		return err
+	}
+	return e.Add(spec, opts)
+}
+
+// added starts the torrent once its info is known, the files are
+// selected as they are first loaded
+func (e *Engine) added(t *Torrent, tt *torrent.Torrent) {
+	select {
+	case <-tt.GotInfo():
+	case <-tt.Closed():
+		return
+	}
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t.Update(tt)
+	if err := checkFiles(t.options, len(tt.Files())); err != nil {
+		t.Error = err.Error()
+		return
+	}
+	if t.options.Paused || !e.config.AutoStart || t.Stream {
+		return
+	}
+	if err := e.startTorrent(t.InfoHash); err != nil {
+		t.Error = err.Error()
+	}
+}
+
+// downloadDir is the directory the data of a torrent belongs in
+func (e *Engine) downloadDir(ih metainfo.Hash, c Config) string {
+	if dir, ok := e.savePaths.Load(ih); ok {
+		return dir.(string)
+	}
+	return c.DownloadDirectory
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// newTestEngine configures an engine on a free local port, without
+// DHT or port mapping, downloading to a temporary directory
+func newTestEngine(t *testing.T, c Config) *Engine {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.IncomingPort = l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	if c.DownloadDirectory == "" {
+		c.DownloadDirectory = t.TempDir()
+	}
+	c.DisableDHT = true
+	c.DisableUTP = true
+	c.DisableIPv6 = true
+	c.DisablePortForwarding = true
+	e := New()
+	if err := e.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	return e
+}
+
+// testTorrent writes the files, by path and size, to dir/name and
+// returns their metainfo
+func testTorrent(t *testing.T, dir, name string, files map[string]int) *metainfo.MetaInfo {
+	for p, size := range files {
+		p = filepath.Join(dir, name, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info := metainfo.Info{PieceLength: 1 << 14}
+	if err := info.BuildFromFilePath(filepath.Join(dir, name)); err != nil {
+		t.Fatal(err)
+	}
+	mi := &metainfo.MetaInfo{}
+	var err error
+	if mi.InfoBytes, err = bencode.Marshal(info); err != nil {
+		t.Fatal(err)
+	}
+	return mi
+}
+
+func TestCheckOptions(t *testing.T) {
+	base := filepath.FromSlash("/data")
+	e := &Engine{config: Config{DownloadDirectory: base}}
+	for _, c := range []struct {
+		opts AddOptions
+		dir  string
+	}{
+		{AddOptions{}, ""},
+		{AddOptions{SavePath: "movies"}, filepath.Join(base, "movies")},
+		{AddOptions{SavePath: "movies/../tv/"}, filepath.Join(base, "tv")},
+		{AddOptions{SavePath: base}, ""},
+		{AddOptions{SavePath: filepath.Join(base, "tv")}, filepath.Join(base, "tv")},
+		{AddOptions{Category: " tv "}, filepath.Join(base, "tv")},
+		{AddOptions{Category: "tv", SavePath: "shows"}, filepath.Join(base, "shows")},
+		{AddOptions{Category: "tv", Stream: true}, ""},
+	} {
+		dir, err := e.checkOptions(&c.opts)
+		if err != nil || dir != c.dir {
+			t.Errorf("%+v: dir %q (%v), want %q", c.opts, dir, err, c.dir)
+		}
+	}
+	for _, opts := range []AddOptions{
+		{SavePath: ".."},
+		{SavePath: "../data2"},
+		{SavePath: filepath.FromSlash("/data2")},
+		{SavePath: filepath.FromSlash("/etc")},
+		{Category: "../etc"},
+		{SavePath: "movies", Stream: true},
+		{Files: []int{-1}},
+		{FileGlobs: []string{"["}},
+		{UploadLimit: -1},
+	} {
+		if dir, err := e.checkOptions(&opts); err == nil {
+			t.Errorf("%+v: accepted with dir %q", opts, dir)
+		}
+	}
+
+	opts := AddOptions{Tags: []string{" a", "b", "", "a "}, Category: " tv "}
+	e.checkOptions(&opts)
+	if !reflect.DeepEqual(opts.Tags, []string{"a", "b"}) || opts.Category != "tv" {
+		t.Errorf("tags %q and category %q not normalised", opts.Tags, opts.Category)
+	}
+	e.config.Storage = StorageMMap
+	if _, err := e.checkOptions(&AddOptions{SavePath: "movies"}); err == nil {
+		t.Error("save path accepted with mmap storage")
+	}
+}
+
+func TestWants(t *testing.T) {
+	for _, c := range []struct {
+		opts *AddOptions
+		path string
+		i    int
+		want bool
+	}{
+		{nil, "Show/a.mkv", 0, true},
+		{&AddOptions{}, "Show/a.mkv", 0, true},
+		{&AddOptions{Files: []int{1}}, "Show/a.mkv", 0, false},
+		{&AddOptions{Files: []int{1}}, "Show/b.mkv", 1, true},
+		{&AddOptions{FileGlobs: []string{"*.mkv"}}, "Show/a.mkv", 0, true},
+		{&AddOptions{FileGlobs: []string{"*.mkv"}}, "Show/a.srt", 0, false},
+		{&AddOptions{FileGlobs: []string{"Subs/*.srt"}}, "Show/Subs/a.srt", 0, true},
+		{&AddOptions{FileGlobs: []string{"Show/*"}}, "Show/a.srt", 0, true},
+		{&AddOptions{FileGlobs: []string{"*.srt"}, Files: []int{2}}, "Show/a.mkv", 2, true},
+	} {
+		if got := c.opts.wants(c.i, c.path); got != c.want {
+			t.Errorf("%+v wants %d %s: %v", c.opts, c.i, c.path, got)
+		}
+	}
+}
+
+func TestAddOptions(t *testing.T) {
+	e := newTestEngine(t, Config{})
+	mi := testTorrent(t, t.TempDir(), "Show", map[string]int{
+		"a.mkv":      1 << 14,
+		"b.mkv":      1 << 14,
+		"Subs/a.srt": 100,
+	})
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Add(spec, AddOptions{Files: []int{3}}); err == nil || errors.Is(err, ErrConflict) {
+		t.Fatalf("file index past the files returned %v", err)
+	}
+
+	spec, _ = torrent.TorrentSpecFromMetaInfoErr(mi)
+	err = e.Add(spec, AddOptions{
+		Paused:        true,
+		Category:      "tv",
+		Tags:          []string{"new", "new"},
+		Files:         []int{1}, //a.mkv, files are sorted by path
+		FileGlobs:     []string{"*.srt"},
+		Sequential:    true,
+		UploadLimit:   1000,
+		DownloadLimit: 2000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ih := spec.InfoHash.HexString()
+	var tt *Torrent
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if tt, err = e.GetTorrentFiles(ih); err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if tt.SavePath != filepath.Join(e.config.DownloadDirectory, "tv") || tt.Category != "tv" ||
+		!reflect.DeepEqual(tt.Tags, []string{"new"}) || !tt.Sequential ||
+		tt.UploadLimit != 1000 || tt.DownloadLimit != 2000 || tt.Started {
+		t.Errorf("options not applied: %+v", tt)
+	}
+	selected := map[string]bool{}
+	for _, f := range tt.Files {
+		selected[f.Path] = f.Priority
+	}
+	want := map[string]bool{"Show/Subs/a.srt": true, "Show/a.mkv": true, "Show/b.mkv": false}
+	if !reflect.DeepEqual(selected, want) {
+		t.Errorf("selected %v, want %v", selected, want)
+	}
+}
+
+func TestAddExisting(t *testing.T) {
+	e := newTestEngine(t, Config{})
+	mi := testTorrent(t, t.TempDir(), "data", map[string]int{"a": 1 << 14})
+	spec, _ := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err := e.Add(spec, AddOptions{Paused: true}); err != nil {
+		t.Fatal(err)
+	}
+	spec, _ = torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err := e.Add(spec, AddOptions{}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("adding again returned %v", err)
+	}
+	if err := New().Add(spec, AddOptions{}); err == nil {
+		t.Fatal("added to an engine which is not configured")
+	}
+}
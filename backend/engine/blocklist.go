@@ -0,0 +1,292 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anacrolix/torrent/iplist"
+)
+
+const (
+	blocklistInterval = 1 * time.Minute
+	//blocked addresses remembered to count each peer once
+	maxBlockedPeers = 1 << 16
+)
+
+// blocklist is the client's iplist.Ranger. It can be reloaded in
+// place (the client only accepts a blocklist on creation) and counts
+// the peers it has blocked.
+type blocklist struct {
+	mut     sync.RWMutex
+	v4, v6  *iplist.IPList
+	path    string
+	modTime time.Time
+	blocked int64
+	seenMut sync.Mutex
+	seen    map[string]bool //blocked addresses, reset when full
+}
+
+// blocklistRanges is a list read by blocklist.read, waiting to be set
+type blocklistRanges struct {
+	v4, v6  *iplist.IPList
+	path    string
+	modTime time.Time
+}
+
+func (b *blocklist) Lookup(ip net.IP) (r iplist.Range, ok bool) {
+	b.mut.RLock()
+	if v4 := ip.To4(); v4 != nil {
+		//IPList also tries the 16 byte form, only trust v4 matches
+		r, ok = b.v4.Lookup(v4)
+		ok = ok && bytes.Compare(r.First, v4) <= 0 && bytes.Compare(v4, r.Last) <= 0
+	} else {
+		r, ok = b.v6.Lookup(ip)
+	}
+	b.mut.RUnlock()
+	if ok {
+		b.block(ip)
+	}
+	return
+}
+
+// block counts a blocked address, the client looks addresses up on
+// every connection attempt
+func (b *blocklist) block(ip net.IP) {
+	b.seenMut.Lock()
+	defer b.seenMut.Unlock()
+	if b.seen[string(ip)] {
+		return
+	}
+	if b.seen == nil || len(b.seen) >= maxBlockedPeers {
+		b.seen = map[string]bool{}
+	}
+	b.seen[string(ip)] = true
+	atomic.AddInt64(&b.blocked, 1)
+}
+
+func (b *blocklist) NumRanges() int {
+	b.mut.RLock()
+	defer b.mut.RUnlock()
+	return b.v4.NumRanges() + b.v6.NumRanges()
+}
+
+// Blocked returns the number of distinct peer addresses blocked
+func (b *blocklist) Blocked() int64 {
+	return atomic.LoadInt64(&b.blocked)
+}
+
+// load (re)reads the list at path, only when the file has changed
+func (b *blocklist) load(path string) error {
+	r, err := b.read(path)
+	if err != nil {
+		return err
+	}
+	b.set(r)
+	return nil
+}
+
+// read reads the list at path without using it, returning nil when
+// the file has not changed
+func (b *blocklist) read(path string) (*blocklistRanges, error) {
+	if path == "" {
+		return &blocklistRanges{}, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	b.mut.RLock()
+	unchanged := path == b.path && info.ModTime().Equal(b.modTime)
+	b.mut.RUnlock()
+	if unchanged {
+		return nil, nil
+	}
+	v4, v6, err := readBlocklist(path)
+	if err != nil {
+		return nil, err
+	}
+	return &blocklistRanges{v4: v4, v6: v6, path: path, modTime: info.ModTime()}, nil
+}
+
+// set replaces the list with r, unless it is nil
+func (b *blocklist) set(r *blocklistRanges) {
+	if r == nil {
+		return
+	}
+	b.mut.Lock()
+	b.v4, b.v6 = r.v4, r.v6
+	b.path, b.modTime = r.path, r.modTime
+	b.mut.Unlock()
+	if r.path != "" {
+		log.Printf("Loaded %d blocklist ranges from %s", r.v4.NumRanges()+r.v6.NumRanges(), r.path)
+	}
+}
+
+// reload polls the blocklist file for changes until done is closed
+func (b *blocklist) reload(path string, done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(blocklistInterval):
+		}
+		if err := b.load(path); err != nil {
+			log.Printf("Blocklist reload failed: %s", err)
+		}
+	}
+}
+
+// readBlocklist parses a P2P, DAT or CIDR format list, which may
+// be gzip compressed
+func readBlocklist(path string) (v4, v6 *iplist.IPList, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	var ranges4, ranges6 []iplist.Range
+	invalid := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		rng, ok, err := parseBlocklistLine(scanner.Text())
+		if err != nil {
+			invalid++
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if len(rng.First) == net.IPv4len {
+			ranges4 = append(ranges4, rng)
+		} else {
+			ranges6 = append(ranges6, rng)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if invalid > 0 {
+		if len(ranges4)+len(ranges6) == 0 {
+			return nil, nil, fmt.Errorf("No valid ranges in %s", path)
+		}
+		log.Printf("Skipped %d invalid blocklist lines", invalid)
+	}
+	return iplist.New(mergeRanges(ranges4)), iplist.New(mergeRanges(ranges6)), nil
+}
+
+// DAT lines start with a range, P2P descriptions may contain commas
+var datLineRe = regexp.MustCompile(`^[0-9A-Fa-f.:]+\s*-\s*[0-9A-Fa-f.:]+\s*,`)
+
+// parseBlocklistLine parses a single line in any supported format,
+// returning !ok for blank lines, comments and allowed DAT entries
+func parseBlocklistLine(line string) (r iplist.Range, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' || line[0] == ';' || strings.HasPrefix(line, "//") {
+		return r, false, nil
+	}
+	if _, in, err := net.ParseCIDR(line); err == nil {
+		//CIDR: 1.2.3.0/24
+		r = iplist.Range{First: in.IP, Last: iplist.IPNetLast(in)}
+		return r, true, nil
+	}
+	switch {
+	case datLineRe.MatchString(line):
+		//DAT: 001.002.003.000 - 001.002.003.255 , 000 , description
+		fields := strings.SplitN(line, ",", 3)
+		if len(fields) >= 2 {
+			//levels above 127 are allowed
+			if level, err := strconv.Atoi(strings.TrimSpace(fields[1])); err == nil && level > 127 {
+				return r, false, nil
+			}
+		}
+		if len(fields) == 3 {
+			r.Description = strings.TrimSpace(fields[2])
+		}
+		ips := strings.SplitN(fields[0], "-", 2)
+		if len(ips) != 2 {
+			return r, false, fmt.Errorf("missing hyphen")
+		}
+		r.First = parseBlocklistIP(ips[0])
+		r.Last = parseBlocklistIP(ips[1])
+	default:
+		//P2P: description:1.2.3.0-1.2.3.255
+		return parseP2PLine(line)
+	}
+	if r.First == nil || r.Last == nil || len(r.First) != len(r.Last) {
+		return r, false, fmt.Errorf("bad IP range")
+	}
+	if v4 := r.First.To4(); v4 != nil {
+		r.First, r.Last = v4, r.Last.To4()
+	}
+	return r, true, nil
+}
+
+func parseP2PLine(line string) (iplist.Range, bool, error) {
+	r, ok, err := iplist.ParseBlocklistP2PLine([]byte(line))
+	if err != nil || !ok {
+		return r, ok, err
+	}
+	if v4 := r.First.To4(); v4 != nil {
+		r.First, r.Last = v4, r.Last.To4()
+	}
+	return r, true, nil
+}
+
+// parseBlocklistIP parses an address, allowing zero padded
+// IPv4 octets as found in DAT lists
+func parseBlocklistIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if parts := strings.Split(s, "."); len(parts) == 4 {
+		for i, p := range parts {
+			if t := strings.TrimLeft(p, "0"); t != "" {
+				parts[i] = t
+			} else {
+				parts[i] = "0"
+			}
+		}
+		s = strings.Join(parts, ".")
+	}
+	return net.ParseIP(s)
+}
+
+// mergeRanges sorts ranges and merges overlapping ones, as
+// required by iplist.New
+func mergeRanges(ranges []iplist.Range) []iplist.Range {
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].First, ranges[j].First) < 0
+	})
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && bytes.Compare(r.First, merged[n-1].Last) <= 0 {
+			if bytes.Compare(r.Last, merged[n-1].Last) > 0 {
+				merged[n-1].Last = r.Last
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
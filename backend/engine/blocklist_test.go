@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseBlocklistLine(t *testing.T) {
+	for _, tc := range []struct {
+		line, first, last string
+		ok                bool
+	}{
+		{"1.2.3.0/24", "1.2.3.0", "1.2.3.255", true},
+		{"001.002.003.000 - 001.002.003.255 , 000 , Some Corp, Inc", "1.2.3.0", "1.2.3.255", true},
+		{"001.002.003.000 - 001.002.003.255 , 200 , allowed", "", "", false},
+		{"Some Corp:1.2.3.0-1.2.3.255", "1.2.3.0", "1.2.3.255", true},
+		{"Microsoft Corp, Inc:1.2.3.0-1.2.3.255", "1.2.3.0", "1.2.3.255", true},
+		{"# comment", "", "", false},
+	} {
+		r, ok, err := parseBlocklistLine(tc.line)
+		if err != nil {
+			t.Errorf("%q: %s", tc.line, err)
+			continue
+		}
+		if ok != tc.ok {
+			t.Errorf("%q: ok %v", tc.line, ok)
+			continue
+		}
+		if ok && (r.First.String() != tc.first || r.Last.String() != tc.last) {
+			t.Errorf("%q: %s-%s", tc.line, r.First, r.Last)
+		}
+	}
+}
+
+func TestBlocklistBlocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	if err := os.WriteFile(path, []byte("1.2.3.0/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := &blocklist{}
+	if err := b.load(path); err != nil {
+		t.Fatal(err)
+	}
+	//the client looks peers up on every connection attempt
+	for _, ip := range []string{"1.2.3.4", "1.2.3.4", "1.2.3.5", "1.2.4.1"} {
+		b.Lookup(net.ParseIP(ip))
+	}
+	if n := b.Blocked(); n != 2 {
+		t.Errorf("%d peers blocked, want 2", n)
+	}
+}
+
+func TestBlocklistConfigure(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.txt"), filepath.Join(dir, "second.txt")
+	os.WriteFile(first, []byte("1.2.3.0/24\n"), 0644)
+	os.WriteFile(second, []byte("1.2.3.0/24\n5.6.7.0/24\n"), 0644)
+	e := newTestEngine(t, Config{Blocklist: first})
+	if n := e.blocklist.NumRanges(); n != 1 {
+		t.Fatalf("%d ranges loaded", n)
+	}
+	//a rejected config keeps the current list
+	c := e.Config()
+	c.Blocklist = second
+	c.ProxyURL = "ftp://proxy.example"
+	if err := e.Configure(c); err == nil {
+		t.Fatal("invalid proxy accepted")
+	}
+	if n := e.blocklist.NumRanges(); n != 1 {
+		t.Errorf("%d ranges after a rejected config", n)
+	}
+	c.ProxyURL = ""
+	if err := e.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	if n := e.blocklist.NumRanges(); n != 2 {
+		t.Errorf("%d ranges after an accepted config", n)
+	}
+}
@@ -1,10 +1,30 @@
 package engine
 
 type Config struct {
-	AutoStart         bool
-	DisableEncryption bool
-	DownloadDirectory string
-	EnableUpload      bool
-	EnableSeeding     bool
-	IncomingPort      int
+	AutoStart             bool
+	DisableEncryption     bool
+	DownloadDirectory     string
+	EnableUpload          bool
+	EnableSeeding         bool
+	IncomingPort          int
+	WatchDirectory        string
+	Blocklist             string
+	ProxyURL              string
+	ProxyOnly             bool
+	EncryptionPolicy      string
+	DisableDHT            bool
+	DisablePEX            bool
+	DisableUTP            bool
+	DisableTCP            bool
+	DisableIPv6           bool
+	DHTBootstrapNodes     []string
+	DisablePortForwarding bool
+	Storage               string
+	StreamCacheMB         int
+	MinFreeSpaceMB        int
+	IncompleteDirectory   string
+	PartExtension         bool
+	ExtractArchives       bool
+	DeleteArchives        bool
+	BlockPrivateURLs      bool
 }
@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// CreateOptions describes a new torrent built from local files
+type CreateOptions struct {
+	PieceLength int64
+	Trackers    []string
+	WebSeeds    []string
+	Private     bool
+	Comment     string
+}
+
+// creating is held while a torrent is hashed, hashing is disk bound
+// so torrents are created one at a time
+var creating = make(chan struct{}, 1)
+
+// CreateTorrent hashes the file or directory at path and returns the
+// resulting metainfo. Hashing stops when ctx is done.
+func CreateTorrent(ctx context.Context, path string, opts CreateOptions) (*metainfo.MetaInfo, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	if l := opts.PieceLength; l != 0 && (l < 16*1024 || l&(l-1) != 0) {
+		return nil, fmt.Errorf("Invalid piece length (%d)", l)
+	}
+	select {
+	case creating <- struct{}{}:
+		defer func() { <-creating }()
+	default:
+		return nil, conflict("Another torrent is being created")
+	}
+	info := metainfo.Info{PieceLength: opts.PieceLength}
+	if opts.Private {
+		private := true
+		info.Private = &private
+	}
+	if err := buildInfo(ctx, &info, path); err != nil {
+		return nil, err
+	}
+	mi := &metainfo.MetaInfo{}
+	mi.SetDefaults()
+	mi.CreatedBy = "Remote Torrent"
+	mi.Comment = opts.Comment
+	mi.UrlList = opts.WebSeeds
+	//one tracker per tier
+	for _, tr := range opts.Trackers {
+		mi.AnnounceList = append(mi.AnnounceList, []string{tr})
+	}
+	if len(opts.Trackers) > 0 {
+		mi.Announce = opts.Trackers[0]
+	}
+	b, err := bencode.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	mi.InfoBytes = b
+	return mi, nil
+}
+
+// buildInfo is metainfo.Info.BuildFromFilePath, reading the files
+// through ctx
+func buildInfo(ctx context.Context, info *metainfo.Info, root string) error {
+	info.Name = filepath.Base(root)
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		if path == root {
+			info.Length = fi.Size()
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		info.Files = append(info.Files, metainfo.FileInfo{
+			Path:   strings.Split(rel, string(filepath.Separator)),
+			Length: fi.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if info.TotalLength() == 0 {
+		return fmt.Errorf("Nothing to share in %s", filepath.Base(root))
+	}
+	sort.Slice(info.Files, func(i, j int) bool {
+		return strings.Join(info.Files[i].Path, "/") < strings.Join(info.Files[j].Path, "/")
+	})
+	if info.PieceLength == 0 {
+		info.PieceLength = metainfo.ChoosePieceLength(info.TotalLength())
+	}
+	err = info.GeneratePieces(func(fi metainfo.FileInfo) (io.ReadCloser, error) {
+		f, err := os.Open(filepath.Join(root, filepath.Join(fi.BestPath()...)))
+		if err != nil {
+			return nil, err
+		}
+		return contextFile{f, ctx}, nil
+	})
+	//the read error is only kept as text
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// contextFile fails reads once its context is done
+type contextFile struct {
+	*os.File
+	ctx context.Context
+}
+
+func (f contextFile) Read(b []byte) (int, error) {
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return f.File.Read(b)
+}
+
+// SeedTorrent adds a created torrent to the engine, reading its data
+// from dir (the parent of the created path) inside the download
+// directory. Seeding needs uploads and seeding enabled.
+func (e *Engine) SeedTorrent(mi *metainfo.MetaInfo, dir string) error {
+	c := e.Config()
+	if !c.EnableSeeding || !c.EnableUpload {
+		return conflict("Seeding is disabled")
+	}
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		return err
+	}
+	opts := AddOptions{}
+	if filepath.Clean(dir) != filepath.Clean(c.DownloadDirectory) {
+		opts.SavePath = dir
+	}
+	return e.Add(spec, opts)
+}
@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestCreateTorrent(t *testing.T) {
+	dir := t.TempDir()
+	want := testTorrent(t, dir, "Show", map[string]int{
+		"a.mkv":      40000,
+		"Subs/a.srt": 100,
+	})
+	mi, err := CreateTorrent(context.Background(), filepath.Join(dir, "Show"), CreateOptions{
+		PieceLength: 1 << 14,
+		Trackers:    []string{"udp://a.example:1337", "udp://b.example:1337"},
+		WebSeeds:    []string{"https://seed.example/"},
+		Comment:     "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mi.InfoBytes, want.InfoBytes) || mi.HashInfoBytes() != want.HashInfoBytes() {
+		t.Fatalf("infohash %s, want %s", mi.HashInfoBytes(), want.HashInfoBytes())
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//40100 bytes in 16 KiB pieces
+	if info.NumPieces() != 3 || len(info.Pieces) != 60 {
+		t.Errorf("%d pieces", info.NumPieces())
+	}
+	if !reflect.DeepEqual([]string(mi.UrlList), []string{"https://seed.example/"}) {
+		t.Errorf("web seeds %q", mi.UrlList)
+	}
+	if mi.Announce != "udp://a.example:1337" || !reflect.DeepEqual(mi.AnnounceList, metainfo.AnnounceList{
+		{"udp://a.example:1337"}, {"udp://b.example:1337"},
+	}) {
+		t.Errorf("trackers %q %q", mi.Announce, mi.AnnounceList)
+	}
+	if mi.Comment != "test" || info.Private != nil {
+		t.Errorf("comment %q, private %v", mi.Comment, info.Private)
+	}
+
+	mi, err = CreateTorrent(context.Background(), filepath.Join(dir, "Show", "a.mkv"), CreateOptions{Private: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := mi.UnmarshalInfo(); info.Name != "a.mkv" || info.Length != 40000 || info.Private == nil || !*info.Private {
+		t.Errorf("single file info %+v", info)
+	}
+}
+
+func TestCreateTorrentInvalid(t *testing.T) {
+	dir := t.TempDir()
+	testTorrent(t, dir, "Show", map[string]int{"a.mkv": 1 << 14, "empty": 0})
+	path := filepath.Join(dir, "Show")
+	for name, opts := range map[string]CreateOptions{
+		"small pieces":   {PieceLength: 1 << 13},
+		"uneven pieces":  {PieceLength: 3 << 14},
+		"missing path":   {},
+		"nothing shared": {},
+	} {
+		p := path
+		switch name {
+		case "missing path":
+			p = filepath.Join(dir, "missing")
+		case "nothing shared":
+			p = filepath.Join(path, "empty")
+		}
+		if _, err := CreateTorrent(context.Background(), p, opts); err == nil {
+			t.Errorf("%s: created", name)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := CreateTorrent(ctx, path, CreateOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled create returned %v", err)
+	}
+	creating <- struct{}{}
+	_, err := CreateTorrent(context.Background(), path, CreateOptions{})
+	<-creating
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("concurrent create returned %v", err)
+	}
+}
+
+func TestSeedTorrent(t *testing.T) {
+	dir := t.TempDir()
+	mi := testTorrent(t, filepath.Join(dir, "shared"), "Show", map[string]int{"a.mkv": 1 << 14})
+	if err := New().SeedTorrent(mi, filepath.Join(dir, "shared")); !errors.Is(err, ErrConflict) {
+		t.Fatalf("seeding with seeding disabled returned %v", err)
+	}
+
+	e := newTestEngine(t, Config{DownloadDirectory: dir, EnableSeeding: true, EnableUpload: true})
+	if err := e.SeedTorrent(mi, filepath.Join(dir, "shared")); err != nil {
+		t.Fatal(err)
+	}
+	e.mut.Lock()
+	st, ok := e.ts[mi.HashInfoBytes().HexString()]
+	if !ok || st.SavePath != filepath.Join(dir, "shared") {
+		t.Fatalf("seeded torrent %+v", st)
+	}
+	tt := st.t
+	e.mut.Unlock()
+	tt.VerifyData()
+	select {
+	case <-tt.Complete().On():
+	case <-time.After(5 * time.Second):
+		t.Fatal("seeded data not found")
+	}
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DeleteTorrentData removes a torrent along with its files, and the
+// directories left empty. Files another torrent also stores are kept
+// and returned.
+func (e *Engine) DeleteTorrentData(infohash string) ([]string, error) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t, err := e.getTorrent(infohash)
+	if err != nil {
+		return nil, err
+	}
+	if t.moving {
+		return nil, conflict("Torrent is being moved")
+	}
+	if t.ExtractStatus == ExtractRunning {
+		return nil, conflict("Torrent is being extracted")
+	}
+	root, files := e.dataFiles(t)
+	shared := map[string]bool{}
+	for ih, other := range e.ts {
+		if ih == t.InfoHash {
+			continue
+		}
+		_, otherFiles := e.dataFiles(other)
+		for _, path := range otherFiles {
+			shared[path] = true
+		}
+	}
+	if err := e.deleteTorrent(infohash); err != nil {
+		return nil, err
+	}
+	//storage may still have files open
+	if t.t != nil {
+		<-t.t.Closed()
+	}
+	return removeFiles(root, files, shared), nil
+}
+
+// removeFiles deletes files, and their part files, which are inside
+// root and not shared, returning the shared files it kept
+func removeFiles(root string, files []string, shared map[string]bool) []string {
+	prefix := filepath.Clean(root) + string(filepath.Separator)
+	kept := []string{}
+	for _, path := range files {
+		if shared[path] {
+			kept = append(kept, path)
+			continue
+		}
+		if !strings.HasPrefix(filepath.Clean(path), prefix) {
+			log.Printf("Not deleting %s, it is outside %s", path, root)
+			continue
+		}
+		for _, p := range []string{path, path + PartExtension} {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				log.Printf("Deleting torrent data failed: %s", err)
+			}
+		}
+		removeEmptyParents(filepath.Dir(path), root)
+	}
+	return kept
+}
+
+// dataFiles returns the directory the files of t are stored in, and
+// their paths. Stream torrents are only kept in memory.
+func (e *Engine) dataFiles(t *Torrent) (string, []string) {
+	if !t.Loaded || t.Stream || t.t == nil {
+		return "", nil
+	}
+	ih := t.t.InfoHash()
+	root := e.downloadDir(ih, e.config)
+	if located, ok := e.located.Load(ih); ok {
+		root = located.(string)
+	}
+	paths := []string{}
+	for _, f := range t.Files {
+		if f != nil {
+			paths = append(paths, filepath.Join(root, filepath.FromSlash(f.Path)))
+		}
+	}
+	return root, paths
+}
+
+// removeEmptyParents removes dir and its parents while they are
+// empty, stopping at root
+func removeEmptyParents(dir, root string) {
+	prefix := filepath.Clean(root) + string(filepath.Separator)
+	for dir = filepath.Clean(dir); strings.HasPrefix(dir, prefix); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestDeleteTorrentData(t *testing.T) {
+	dir := t.TempDir()
+	//both torrents store show/a.mkv
+	single := testTorrent(t, dir, "show", map[string]int{"a.mkv": 1 << 14})
+	full := testTorrent(t, dir, "show", map[string]int{"a.mkv": 1 << 14, "Subs/en/a.srt": 100})
+	e := newTestEngine(t, Config{DownloadDirectory: dir})
+	add := func(mi *metainfo.MetaInfo) string {
+		spec, _ := torrent.TorrentSpecFromMetaInfoErr(mi)
+		if err := e.Add(spec, AddOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		return spec.InfoHash.HexString()
+	}
+	singleIH, fullIH := add(single), add(full)
+	//left by part file storage
+	os.WriteFile(filepath.Join(dir, "show", "Subs", "en", "a.srt"+PartExtension), nil, 0644)
+
+	kept, err := e.DeleteTorrentData(fullIH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "show", "a.mkv")}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept %q, want %q", kept, want)
+	}
+	if !exists(filepath.Join(dir, "show", "a.mkv")) {
+		t.Error("shared file deleted")
+	}
+	if exists(filepath.Join(dir, "show", "Subs")) {
+		t.Error("empty directories left")
+	}
+	if _, err := e.DeleteTorrentData(fullIH); err == nil {
+		t.Error("deleted twice")
+	}
+
+	if _, err := e.DeleteTorrentData(singleIH); err != nil {
+		t.Fatal(err)
+	}
+	if exists(filepath.Join(dir, "show")) || !exists(dir) {
+		t.Error("torrent directory left or download directory removed")
+	}
+}
+
+func TestRemoveFiles(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "downloads")
+	testTorrent(t, root, "show", map[string]int{"a.mkv": 10})
+	testTorrent(t, dir, "other", map[string]int{"b.mkv": 10})
+	os.WriteFile(filepath.Join(root, "show", "a.mkv"+PartExtension), nil, 0644)
+	outside := filepath.Join(root, "..", "other", "b.mkv")
+	removeFiles(root, []string{filepath.Join(root, "show", "a.mkv"), outside}, nil)
+	if exists(filepath.Join(root, "show")) {
+		t.Error("file, part file or directory left")
+	}
+	if !exists(outside) || !exists(root) {
+		t.Error("removed outside the save directory")
+	}
+}
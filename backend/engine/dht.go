@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/torrent"
+)
+
+// applyNetwork applies the protocol toggles and DHT bootstrap nodes
+func applyNetwork(config *torrent.ClientConfig, c Config) error {
+	if c.DisableTCP && c.DisableUTP && c.ProxyURL == "" {
+		return fmt.Errorf("TCP and uTP cannot both be disabled")
+	}
+	config.NoDHT = c.DisableDHT
+	config.DisablePEX = c.DisablePEX
+	config.DisableUTP = c.DisableUTP
+	config.DisableTCP = c.DisableTCP
+	config.DisableIPv6 = c.DisableIPv6
+	if len(c.DHTBootstrapNodes) > 0 {
+		for _, hp := range c.DHTBootstrapNodes {
+			if _, _, err := net.SplitHostPort(hp); err != nil {
+				return fmt.Errorf("Invalid DHT bootstrap node (%s)", hp)
+			}
+		}
+		nodes := append([]string(nil), c.DHTBootstrapNodes...)
+		config.DhtStartingNodes = func(network string) dht.StartingNodesGetter {
+			return func() ([]dht.Addr, error) {
+				return dht.ResolveHostPorts(nodes)
+			}
+		}
+	}
+	return nil
+}
+
+// dhtStats sums the routing table stats of all DHT servers
+func dhtStats(client *torrent.Client, s *Stats) {
+	for _, ds := range client.DhtServers() {
+		ss, ok := ds.Stats().(dht.ServerStats)
+		if !ok {
+			continue
+		}
+		s.DHTServers++
+		s.DHTNodes += ss.Nodes
+		s.DHTGoodNodes += ss.GoodNodes
+		s.DHTBadNodes += int(ss.BadNodes)
+	}
+}
@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// incoming connections within this window mark the port as open
+const incomingWindow = 10 * time.Minute
+
+// NetworkDiagnostics describes whether peers can connect to us
+type NetworkDiagnostics struct {
+	IncomingPort        int
+	ListenAddrs         []string
+	Listening           bool
+	IncomingConnections int64
+	LastIncoming        *time.Time
+	IncomingRecently    bool
+	PortMapStatus       string
+	ExternalAddress     string
+	PortStatus          string //"open" or "unknown"
+	PortCheckError      string
+	//dialing our own external address only succeeds when the router
+	//loops it back, so "failed" does not mean the port is closed
+	HairpinCheck string //"ok", "failed" or "" when not tried
+}
+
+// incomingStats counts peer connections which we accepted
+type incomingStats struct {
+	mut   sync.Mutex
+	count int64
+	last  time.Time
+}
+
+func (in *incomingStats) reset() {
+	in.mut.Lock()
+	in.count, in.last = 0, time.Time{}
+	in.mut.Unlock()
+}
+
+// handshake is the client's CompletedHandshake callback
+func (in *incomingStats) handshake(pc *torrent.PeerConn, _ torrent.InfoHash) {
+	if pc.Discovery != torrent.PeerSourceIncoming {
+		return
+	}
+	in.mut.Lock()
+	in.count++
+	in.last = time.Now()
+	in.mut.Unlock()
+}
+
+// NetworkDiagnostics checks the listeners and whether the incoming
+// port is reachable. Only a recent incoming connection proves the port
+// is open, dialing the mapped external address from inside the network
+// is reported separately as a hairpin check.
+func (e *Engine) NetworkDiagnostics() (*NetworkDiagnostics, error) {
+	e.mut.Lock()
+	client := e.client
+	port := e.config.IncomingPort
+	e.mut.Unlock()
+	if client == nil {
+		return nil, fmt.Errorf("Engine not configured")
+	}
+	d := &NetworkDiagnostics{IncomingPort: port, ListenAddrs: []string{}}
+	tcp := false
+	for _, addr := range client.ListenAddrs() {
+		d.ListenAddrs = append(d.ListenAddrs, addr.Network()+"://"+addr.String())
+		tcp = tcp || addr.Network() == "tcp"
+	}
+	d.Listening = len(d.ListenAddrs) > 0
+	if tcp {
+		//the tcp listener should accept local connections
+		d.Listening = dialCheck(net.JoinHostPort("localhost", strconv.Itoa(port))) == nil
+	}
+	e.incoming.mut.Lock()
+	d.IncomingConnections = e.incoming.count
+	if last := e.incoming.last; !last.IsZero() {
+		d.LastIncoming = &last
+		d.IncomingRecently = time.Since(last) < incomingWindow
+	}
+	e.incoming.mut.Unlock()
+	var s Stats
+	e.portmap.stats(&s)
+	d.PortMapStatus = s.PortMapStatus
+	d.ExternalAddress = s.ExternalAddress
+	if d.ExternalAddress != "" {
+		if err := dialCheck(d.ExternalAddress); err != nil {
+			d.HairpinCheck = "failed"
+		} else {
+			d.HairpinCheck = "ok"
+		}
+	}
+	switch {
+	case d.IncomingRecently:
+		d.PortStatus = "open"
+	case d.ExternalAddress != "":
+		d.PortStatus = "unknown"
+		d.PortCheckError = "No recent incoming connections, hairpin check " + d.HairpinCheck + " (inconclusive)"
+	default:
+		d.PortStatus = "unknown"
+		d.PortCheckError = "No recent incoming connections and external address unknown"
+	}
+	return d, nil
+}
+
+func dialCheck(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mapped fakes a port mapping of the engine to external
+func mapped(e *Engine, external string) {
+	e.portmap.mut.Lock()
+	e.portmap.status, e.portmap.protocol, e.portmap.external = "mapped", "upnp", external
+	e.portmap.mut.Unlock()
+}
+
+func TestNetworkDiagnostics(t *testing.T) {
+	e := newTestEngine(t, Config{})
+	d, err := e.NetworkDiagnostics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Listening || d.PortStatus != "unknown" || d.HairpinCheck != "" || d.PortMapStatus != "disabled" {
+		t.Fatalf("unmapped diagnostics %+v", d)
+	}
+
+	//the router loops our external address back to the listener
+	mapped(e, net.JoinHostPort("127.0.0.1", strconv.Itoa(d.IncomingPort)))
+	d, _ = e.NetworkDiagnostics()
+	if d.HairpinCheck != "ok" || d.PortStatus != "unknown" || !strings.Contains(d.PortCheckError, "inconclusive") {
+		t.Errorf("hairpin diagnostics %+v", d)
+	}
+
+	//the router does not loop it back
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+	mapped(e, closed)
+	d, _ = e.NetworkDiagnostics()
+	if d.HairpinCheck != "failed" || d.PortStatus != "unknown" || d.ExternalAddress != closed {
+		t.Errorf("failed hairpin diagnostics %+v", d)
+	}
+
+	//peers connected to us, whatever the hairpin check says
+	e.incoming.mut.Lock()
+	e.incoming.count, e.incoming.last = 3, time.Now()
+	e.incoming.mut.Unlock()
+	d, _ = e.NetworkDiagnostics()
+	if d.PortStatus != "open" || d.PortCheckError != "" || d.IncomingConnections != 3 || !d.IncomingRecently {
+		t.Errorf("diagnostics after incoming connections %+v", d)
+	}
+
+	e.incoming.mut.Lock()
+	e.incoming.last = time.Now().Add(-incomingWindow - time.Minute)
+	e.incoming.mut.Unlock()
+	d, _ = e.NetworkDiagnostics()
+	if d.PortStatus != "unknown" || d.IncomingRecently || d.LastIncoming == nil {
+		t.Errorf("diagnostics after old incoming connections %+v", d)
+	}
+}
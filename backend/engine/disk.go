@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+const (
+	diskInterval  = 10 * time.Second
+	errorDiskFull = "Disk full"
+)
+
+func freeSpace(dir string) (int64, error) {
+	u, err := disk.Usage(dir)
+	if err != nil {
+		return 0, err
+	}
+	return int64(u.Free), nil
+}
+
+// watchDisk pauses downloading torrents when free space drops below
+// min and resumes them once space is freed, until done is closed
+func (e *Engine) watchDisk(dir string, min int64, done chan struct{}) {
+	for {
+		if free, err := freeSpace(dir); err != nil {
+			log.Printf("Free space check failed: %s", err)
+		} else {
+			e.mut.Lock()
+			e.checkDisk(free, min)
+			e.mut.Unlock()
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(diskInterval):
+		}
+	}
+}
+
+func (e *Engine) checkDisk(free, min int64) {
+	if free < min && !e.diskFull {
+		e.diskFull = true
+		log.Printf("Free space below %d MB, pausing downloads", min>>20)
+		for ih, t := range e.ts {
+			if !t.Started || t.Stream || t.Percent >= 100 {
+				continue
+			}
+			e.stopTorrent(ih)
+			t.diskPaused = true
+			t.Error = errorDiskFull
+		}
+	} else if free >= min && e.diskFull {
+		e.diskFull = false
+		log.Printf("Free space recovered, resuming downloads")
+		for ih, t := range e.ts {
+			if !t.diskPaused {
+				continue
+			}
+			t.diskPaused = false
+			t.Error = ""
+			if err := e.startTorrent(ih); err != nil {
+				t.Error = err.Error()
+			}
+		}
+	}
+}
+
+// checkSpace ensures the remaining selected size of t fits on the
+// disk without crossing the free space threshold
+func (e *Engine) checkSpace(t *Torrent) error {
+	return e.checkNeeded(t, t.Size-t.Downloaded)
+}
+
+// checkNeeded ensures needed more bytes of t fit on the disk without
+// crossing the free space threshold
+func (e *Engine) checkNeeded(t *Torrent, needed int64) error {
+	if !t.Loaded || t.t == nil {
+		return nil
+	}
+	dir := e.downloadDir(t.t.InfoHash(), e.config)
+	if e.config.IncompleteDirectory != "" {
+		dir = e.config.IncompleteDirectory
+	}
+	if dir == "" {
+		return nil
+	}
+	if needed <= 0 {
+		return nil
+	}
+	free, err := freeSpace(dir)
+	if err != nil {
+		return nil
+	}
+	available := free - int64(e.config.MinFreeSpaceMB)<<20
+	if needed > available {
+		if available < 0 {
+			available = 0
+		}
+		return fmt.Errorf("Not enough disk space (%d MB needed, %d MB available)", needed>>20, available>>20)
+	}
+	return nil
+}
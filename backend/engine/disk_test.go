@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+)
+
+func TestFileSelectionSpace(t *testing.T) {
+	mi := testTorrent(t, t.TempDir(), "show", map[string]int{"a.mkv": 1 << 14, "b.mkv": 1 << 14})
+	e := newTestEngine(t, Config{})
+	spec, _ := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err := e.Add(spec, AddOptions{Files: []int{0}}); err != nil {
+		t.Fatal(err)
+	}
+	ih := spec.InfoHash.HexString()
+	e.GetTorrents()
+	if err := e.StartTorrent(ih); err != nil {
+		t.Fatal(err)
+	}
+	//more free space than any disk has must be kept
+	e.mut.Lock()
+	e.config.MinFreeSpaceMB = 1 << 40
+	e.mut.Unlock()
+	if err := e.UpdateFileSelection(ih, []string{"show/b.mkv"}, true); err == nil || !strings.Contains(err.Error(), "Not enough disk space") {
+		t.Fatalf("selected without space: %v", err)
+	}
+	e.mut.Lock()
+	selected := e.ts[ih].Files[1].Priority
+	e.mut.Unlock()
+	if selected {
+		t.Error("file selected without space")
+	}
+	if err := e.UpdateFileSelection(ih, []string{"show/a.mkv"}, false); err != nil {
+		t.Errorf("deselecting: %s", err)
+	}
+	if err := e.StopTorrent(ih); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.UpdateFileSelection(ih, []string{"show/b.mkv"}, true); err != nil {
+		t.Errorf("selecting in a stopped torrent: %s", err)
+	}
+}
+
+func TestDiskWatchDisabled(t *testing.T) {
+	e := newTestEngine(t, Config{})
+	c := e.Config()
+	e.mut.Lock()
+	e.diskFull = true
+	e.mut.Unlock()
+	//no threshold, torrents paused under the previous one resume
+	if err := e.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	if e.Stats().DiskFull {
+		t.Error("still paused without a threshold")
+	}
+}
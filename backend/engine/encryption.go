@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/mse"
+)
+
+// Encryption policies for peer connections
+const (
+	EncryptionPrefer  = "prefer"  //obfuscate, fall back to plaintext
+	EncryptionRequire = "require" //only RC4 encrypted connections
+	EncryptionAllow   = "allow"   //plaintext first, accept obfuscation
+	EncryptionDisable = "disable" //only plaintext connections
+)
+
+// encryptionPolicy returns the configured policy, falling back
+// to the older DisableEncryption flag
+func encryptionPolicy(c Config) (string, error) {
+	switch c.EncryptionPolicy {
+	case "":
+		if c.DisableEncryption {
+			return EncryptionDisable, nil
+		}
+		return EncryptionPrefer, nil
+	case EncryptionPrefer, EncryptionRequire, EncryptionAllow, EncryptionDisable:
+		return c.EncryptionPolicy, nil
+	}
+	return "", fmt.Errorf("Invalid encryption policy (%s)", c.EncryptionPolicy)
+}
+
+// applyEncryption maps a policy onto the header obfuscation settings
+func applyEncryption(config *torrent.ClientConfig, policy string) {
+	switch policy {
+	case EncryptionPrefer:
+		config.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{Preferred: true}
+	case EncryptionRequire:
+		config.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{Preferred: true, RequirePreferred: true}
+		config.CryptoProvides = mse.CryptoMethodRC4
+		config.CryptoSelector = func(provided mse.CryptoMethod) mse.CryptoMethod {
+			return mse.CryptoMethodRC4
+		}
+	case EncryptionAllow:
+		config.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{}
+	case EncryptionDisable:
+		config.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{RequirePreferred: true}
+	}
+}
@@ -3,13 +3,17 @@ package engine
 import (
 	"encoding/hex"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
 )
 
 // the Engine Cloud Torrent engine, backed by anacrolix/torrent
@@ -17,12 +21,26 @@ type Engine struct {
 	mut      sync.Mutex
 	cacheDir string
 	client   *torrent.Client
+	listener net.Listener //see listenPeers
+	storage  storage.ClientImplCloser
+	stream   *memoryStorage
 	config   Config
 	ts       map[string]*Torrent
+	//closed on reconfigure, stops background pollers
+	done      chan struct{}
+	blocklist *blocklist
+	portmap   *portMapper
+	incoming  incomingStats
+	diskFull  bool
+	//torrent data locations, see torrentDir
+	located   sync.Map
+	moved     sync.Map
+	savePaths sync.Map
+	uploads   sync.Map //limitStorage by infohash, see peerMessage
 }
 
 func New() *Engine {
-	return &Engine{ts: map[string]*Torrent{}}
+	return &Engine{ts: map[string]*Torrent{}, blocklist: &blocklist{}, portmap: &portMapper{}}
 }
 
 func (e *Engine) Config() Config {
@@ -31,60 +49,164 @@ func (e *Engine) Config() Config {
 
 func (e *Engine) Configure(c Config) error {
 	//recieve config
-	if e.client != nil {
-		e.client.Close()
-		time.Sleep(1 * time.Second)
-	}
 	if c.IncomingPort <= 0 {
 		return fmt.Errorf("Invalid incoming port (%d)", c.IncomingPort)
 	}
+	policy, err := encryptionPolicy(c)
+	if err != nil {
+		return err
+	}
+	if err := validStorage(c.Storage); err != nil {
+		return err
+	}
+	if c.IncompleteDirectory != "" && c.Storage == StorageMMap {
+		return fmt.Errorf("Incomplete directory is not supported with mmap storage")
+	}
+	if c.PartExtension && c.Storage == StorageMMap {
+		return fmt.Errorf("Part extension is not supported with mmap storage")
+	}
+	//the current list stays in use until the config is accepted
+	ranges, err := e.blocklist.read(c.Blocklist)
+	if err != nil {
+		return fmt.Errorf("Invalid blocklist: %s", err)
+	}
 
 	config := torrent.NewDefaultClientConfig()
 	config.DataDir = c.DownloadDirectory
 	config.NoUpload = !c.EnableUpload
 	config.Seed = c.EnableSeeding
 	config.ListenPort = c.IncomingPort
+	//the engine maps the port itself, to report the mapping status
+	config.NoDefaultPortForwarding = true
+	config.Callbacks.CompletedHandshake = e.incoming.handshake
+	config.Callbacks.ReadMessage = e.peerMessage
+	applyEncryption(config, policy)
+	if err := applyNetwork(config, c); err != nil {
+		return err
+	}
+	if c.Blocklist != "" {
+		config.IPBlocklist = e.blocklist
+	}
+	var dialer torrent.Dialer
+	listenTCP := false
+	if c.ProxyURL != "" {
+		u, err := ParseProxyURL(c.ProxyURL)
+		if err != nil {
+			return err
+		}
+		if dialer, err = proxyDialer(u); err != nil {
+			return err
+		}
+		listenTCP = applyProxy(config, u, c.ProxyOnly)
+	}
+	//config is valid, replace the client
+	e.portmap.close()
+	if e.client != nil {
+		e.client.Close()
+		e.closeListener()
+		time.Sleep(1 * time.Second)
+	}
+	//the piece completion database can only be opened once
+	if e.storage != nil {
+		e.storage.Close()
+		e.storage = nil
+	}
+	store, err := openStorage(c, e.torrentDir(c))
+	if err != nil {
+		return err
+	}
+	config.DefaultStorage = store
 	client, err := torrent.NewClient(config)
 	if err != nil {
+		store.Close()
 		return err
 	}
+	var listener net.Listener
+	if listenTCP {
+		if listener, err = listenPeers(config); err != nil {
+			client.Close()
+			store.Close()
+			return err
+		}
+		client.AddListener(listener)
+	}
+	e.blocklist.set(ranges)
+	e.incoming.reset()
+	if dialer != nil {
+		client.AddDialer(dialer)
+	}
+	streamCache := c.StreamCacheMB
+	if streamCache <= 0 {
+		streamCache = defaultStreamCacheMB
+	}
+	if !c.DisablePortForwarding && config.AcceptPeerConnections {
+		e.portmap.start(c.IncomingPort)
+	}
 	e.mut.Lock()
 	e.config = c
 	e.client = client
+	e.listener = listener
+	e.storage = store
+	e.stream = newMemoryStorage(int64(streamCache) << 20)
+	if e.done != nil {
+		close(e.done)
+	}
+	e.done = make(chan struct{})
+	if c.WatchDirectory != "" {
+		go e.watch(c.WatchDirectory, e.done)
+	}
+	if c.Blocklist != "" {
+		go e.blocklist.reload(c.Blocklist, e.done)
+	}
+	//also resumes torrents paused under a previous threshold
+	diskDir := c.DownloadDirectory
+	if c.IncompleteDirectory != "" {
+		diskDir = c.IncompleteDirectory
+	}
+	if c.MinFreeSpaceMB > 0 {
+		go e.watchDisk(diskDir, int64(c.MinFreeSpaceMB)<<20, e.done)
+	} else {
+		e.checkDisk(0, 0)
+	}
 	e.mut.Unlock()
 	//reset
 	e.GetTorrents()
 	return nil
 }
 
-func (e *Engine) NewMagnet(magnetURI string) error {
-	tt, err := e.client.AddMagnet(magnetURI)
-	if err != nil {
-		return err
+// Close removes the port mapping and shuts down the client
+func (e *Engine) Close() {
+	e.portmap.close()
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if e.done != nil {
+		close(e.done)
+		e.done = nil
+	}
+	if e.client != nil {
+		e.client.Close()
+	}
+	e.closeListener()
+	if e.storage != nil {
+		e.storage.Close()
 	}
-	return e.newTorrent(tt)
 }
 
-func (e *Engine) NewTorrent(spec *torrent.TorrentSpec) error {
-	tt, _, err := e.client.AddTorrentSpec(spec)
-	if err != nil {
-		return err
+// closeListener closes the TCP listener added while using a proxy,
+// the client does not close listeners it was given
+func (e *Engine) closeListener() {
+	if e.listener != nil {
+		e.listener.Close()
+		e.listener = nil
 	}
-	return e.newTorrent(tt)
 }
 
-func (e *Engine) newTorrent(tt *torrent.Torrent) error {
-	t := e.upsertTorrent(tt)
-	go func() {
-		<-t.t.GotInfo()
-		// Update the torrent to ensure Files array is initialized
-		t.Update(tt)
-		// Check if AutoStart is enabled in config
-		if e.config.AutoStart {
-			e.StartTorrent(t.InfoHash)
-		}
-	}()
-	return nil
+func (e *Engine) NewMagnet(magnetURI string) error {
+	return e.AddMagnet(magnetURI, AddOptions{})
+}
+
+func (e *Engine) NewTorrent(spec *torrent.TorrentSpec) error {
+	return e.Add(spec, AddOptions{})
 }
 
 // GetTorrents moves torrents out of the anacrolix/torrent
@@ -97,7 +219,10 @@ func (e *Engine) GetTorrents() map[string]*Torrent {
 		return nil
 	}
 	for _, tt := range e.client.Torrents() {
-		e.upsertTorrent(tt)
+		t := e.upsertTorrent(tt)
+		e.checkCompleted(t, tt)
+		e.checkExtract(t)
+		e.updateSequential(t)
 	}
 	return e.ts
 }
@@ -106,7 +231,7 @@ func (e *Engine) upsertTorrent(tt *torrent.Torrent) *Torrent {
 	ih := tt.InfoHash().HexString()
 	torrent, ok := e.ts[ih]
 	if !ok {
-		torrent = &Torrent{InfoHash: ih}
+		torrent = &Torrent{InfoHash: ih, AddedAt: time.Now()}
 		e.ts[ih] = torrent
 	}
 	//update torrent fields using underlying torrent
@@ -121,7 +246,7 @@ func (e *Engine) getTorrent(infohash string) (*Torrent, error) {
 	}
 	t, ok := e.ts[ih.HexString()]
 	if !ok {
-		return t, fmt.Errorf("Missing torrent %x", ih)
+		return t, notFound("Missing torrent %x", ih)
 	}
 	return t, nil
 }
@@ -134,11 +259,28 @@ func (e *Engine) getOpenTorrent(infohash string) (*Torrent, error) {
 	return t, nil
 }
 
+// StartTorrent downloads the selected files of a torrent
 func (e *Engine) StartTorrent(infohash string) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return e.startTorrent(infohash)
+}
+
+// startTorrent is StartTorrent with e.mut held
+func (e *Engine) startTorrent(infohash string) error {
 	t, err := e.getOpenTorrent(infohash)
 	if err != nil {
 		return err
 	}
+	if t.Stream {
+		return conflict("Stream torrents download as they are read")
+	}
+	if err := e.checkSpace(t); err != nil {
+		return err
+	}
+	t.diskPaused = false
+	t.moveFailed = false
+	t.Error = ""
 
 	// Set started flag
 	wasStarted := t.Started
@@ -174,13 +316,23 @@ func (e *Engine) StartTorrent(infohash string) error {
 	return nil
 }
 
+// StopTorrent stops downloading a torrent, it stays loaded
 func (e *Engine) StopTorrent(infohash string) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return e.stopTorrent(infohash)
+}
+
+// stopTorrent is StopTorrent with e.mut held
+func (e *Engine) stopTorrent(infohash string) error {
 	t, err := e.getTorrent(infohash)
 	if err != nil {
 		return err
 	}
+	//stopped by the user, don't resume when space is freed
+	t.diskPaused = false
 	if !t.Started {
-		return fmt.Errorf("Already stopped")
+		return conflict("Already stopped")
 	}
 	// Don't drop the torrent, just mark it as stopped
 	// and cancel all file downloads
@@ -191,14 +343,29 @@ func (e *Engine) StopTorrent(infohash string) error {
 		}
 	}
 	// Instead of dropping, just cancel downloads but keep the torrent
+	t.window = nil
 	if t.t != nil {
+		// Files started with Download() keep their own priority
+		for _, f := range t.Files {
+			if f != nil && f.f != nil {
+				f.f.SetPriority(torrent.PiecePriorityNone)
+			}
+		}
 		// Cancel all piece requests but don't drop the torrent
 		t.t.CancelPieces(0, t.t.NumPieces())
 	}
 	return nil
 }
 
+// DeleteTorrent removes a torrent, its data is left on disk
 func (e *Engine) DeleteTorrent(infohash string) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return e.deleteTorrent(infohash)
+}
+
+// deleteTorrent is DeleteTorrent with e.mut held
+func (e *Engine) deleteTorrent(infohash string) error {
 	t, err := e.getTorrent(infohash)
 	if err != nil {
 		return err
@@ -206,12 +373,63 @@ func (e *Engine) DeleteTorrent(infohash string) error {
 	os.Remove(filepath.Join(e.cacheDir, infohash+".torrent"))
 	delete(e.ts, t.InfoHash)
 	ih, _ := str2ih(infohash)
+	e.savePaths.Delete(ih)
+	e.uploads.Delete(ih)
 	if tt, ok := e.client.Torrent(ih); ok {
 		tt.Drop()
 	}
 	return nil
 }
 
+// RecheckTorrent re-hashes all pieces of a torrent in the background
+func (e *Engine) RecheckTorrent(infohash string) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t, err := e.getTorrent(infohash)
+	if err != nil {
+		return err
+	}
+	if !t.Loaded || t.t == nil {
+		return conflict("Torrent metadata not loaded yet")
+	}
+	go t.t.VerifyData()
+	return nil
+}
+
+// SetCategory changes the category of a torrent, its data stays in
+// the save path it was added with
+func (e *Engine) SetCategory(infohash, category string) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t, err := e.getTorrent(infohash)
+	if err != nil {
+		return err
+	}
+	t.Category = strings.TrimSpace(category)
+	return nil
+}
+
+// SetLimits changes the rate limits of a torrent in bytes per second,
+// 0 is unlimited
+func (e *Engine) SetLimits(infohash string, download, upload int) error {
+	if download < 0 || upload < 0 {
+		return fmt.Errorf("Invalid rate limit")
+	}
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t, err := e.getTorrent(infohash)
+	if err != nil {
+		return err
+	}
+	if t.storage == nil {
+		return conflict("Torrent can't be rate limited")
+	}
+	t.storage.setLimits(download, upload)
+	t.DownloadLimit = download
+	t.UploadLimit = upload
+	return nil
+}
+
 func (e *Engine) StartFile(infohash, filepath string) error {
 	t, err := e.getOpenTorrent(infohash)
 	if err != nil {
@@ -225,10 +443,10 @@ func (e *Engine) StartFile(infohash, filepath string) error {
 		}
 	}
 	if f == nil {
-		return fmt.Errorf("Missing file %s", filepath)
+		return notFound("Missing file %s", filepath)
 	}
 	if f.Started {
-		return fmt.Errorf("Already started")
+		return conflict("Already started")
 	}
 	t.Started = true
 	f.Started = true
@@ -246,16 +464,58 @@ func (e *Engine) GetTorrentFiles(infohash string) (*Torrent, error) {
 
 	t, ok := e.ts[infohash]
 	if !ok {
-		return nil, fmt.Errorf("Torrent not found")
+		return nil, notFound("Torrent not found")
 	}
 
 	if !t.Loaded {
-		return nil, fmt.Errorf("Torrent metadata not loaded yet")
+		return nil, conflict("Torrent metadata not loaded yet")
 	}
 
 	return t, nil
 }
 
+// Metainfo returns the .torrent contents of a loaded torrent
+func (e *Engine) Metainfo(infohash string) (*metainfo.MetaInfo, error) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	t, err := e.getTorrent(infohash)
+	if err != nil {
+		return nil, err
+	}
+	if !t.Loaded || t.t == nil {
+		return nil, conflict("Torrent metadata not loaded yet")
+	}
+	mi := t.t.Metainfo()
+	mi.Comment = ""
+	mi.CreatedBy = "Remote Torrent"
+	if trackers := mi.UpvertedAnnounceList().DistinctValues(); len(trackers) > 0 {
+		mi.Announce = trackers[0]
+	}
+	return &mi, nil
+}
+
+// Magnet returns a magnet URI for a torrent, including its trackers
+func (e *Engine) Magnet(infohash string) (string, error) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	t, err := e.getTorrent(infohash)
+	if err != nil {
+		return "", err
+	}
+	ih, _ := str2ih(infohash)
+	m := metainfo.Magnet{InfoHash: ih, DisplayName: t.Name}
+	if t.t != nil {
+		mi := t.t.Metainfo()
+		m.Trackers = mi.UpvertedAnnounceList().DistinctValues()
+		if len(mi.UrlList) > 0 {
+			m.Params = url.Values{"ws": mi.UrlList}
+		}
+	}
+	return m.String(), nil
+}
+
 // UpdateFileSelection updates which files should be downloaded
 func (e *Engine) UpdateFileSelection(infohash string, filePaths []string, download bool) error {
 	e.mut.Lock()
@@ -263,11 +523,11 @@ func (e *Engine) UpdateFileSelection(infohash string, filePaths []string, downlo
 
 	t, ok := e.ts[infohash]
 	if !ok {
-		return fmt.Errorf("Torrent not found")
+		return notFound("Torrent not found")
 	}
 
 	if !t.Loaded {
-		return fmt.Errorf("Torrent metadata not loaded yet")
+		return conflict("Torrent metadata not loaded yet")
 	}
 
 	// Create a map for quick lookup
@@ -276,6 +536,19 @@ func (e *Engine) UpdateFileSelection(infohash string, filePaths []string, downlo
 		filePathMap[path] = true
 	}
 
+	// Started torrents download the new selection right away
+	if download && t.Started {
+		needed := int64(0)
+		for _, file := range t.Files {
+			if file.f != nil && (file.Priority || filePathMap[file.Path]) {
+				needed += file.f.Length() - file.f.BytesCompleted()
+			}
+		}
+		if err := e.checkNeeded(t, needed); err != nil {
+			return err
+		}
+	}
+
 	// Update priority for matching files
 	for _, file := range t.Files {
 		if filePathMap[file.Path] {
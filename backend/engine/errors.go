@@ -0,0 +1,35 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors which callers may need to tell apart, test for them with
+// errors.Is. The returned errors keep their own messages.
+var (
+	ErrNotFound = errors.New("Not found")
+	ErrConflict = errors.New("Conflict")
+)
+
+// kindError is an error of one of the kinds above
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (k kindError) Error() string {
+	return k.msg
+}
+
+func (k kindError) Unwrap() error {
+	return k.kind
+}
+
+func notFound(format string, args ...interface{}) error {
+	return kindError{ErrNotFound, fmt.Sprintf(format, args...)}
+}
+
+func conflict(format string, args ...interface{}) error {
+	return kindError{ErrConflict, fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,319 @@
+package engine
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/ulikunitz/xz"
+)
+
+// Extraction states of a torrent
+const (
+	ExtractRunning = "extracting"
+	ExtractDone    = "extracted"
+	ExtractFailed  = "failed"
+)
+
+// supported archive extensions, longest first
+var archiveExts = []string{".tar.gz", ".tar.xz", ".tar.bz2", ".tgz", ".txz", ".tbz2", ".tar", ".zip"}
+
+// split archives are named like archive.tar.gz.001
+var archivePartRe = regexp.MustCompile(`^(.+)\.(\d{3})$`)
+
+// archive is a (possibly multi-part) archive within a torrent
+type archive struct {
+	ext   string
+	parts []string
+	files []*File
+	dest  string
+	size  int64
+}
+
+// archiveExt returns the archive extension of name, if any
+func archiveExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(lower, ext) && len(lower) > len(ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// findArchives groups the completed files of t into archives,
+// multi-part archives are only supported for tar
+func findArchives(dir string, t *Torrent) []*archive {
+	byPath := map[string]*archive{}
+	var archives []*archive
+	for _, f := range t.Files {
+		if f == nil || !f.Priority {
+			continue
+		}
+		path := filepath.Join(dir, filepath.FromSlash(f.Path))
+		name, part := path, ""
+		if m := archivePartRe.FindStringSubmatch(path); m != nil {
+			name, part = m[1], m[2]
+		}
+		ext := archiveExt(name)
+		if ext == "" || (part != "" && ext == ".zip") {
+			continue
+		}
+		a, ok := byPath[name]
+		if !ok {
+			a = &archive{ext: ext, dest: name[:len(name)-len(ext)]}
+			byPath[name] = a
+			archives = append(archives, a)
+		}
+		a.parts = append(a.parts, path)
+		a.files = append(a.files, f)
+		a.size += f.Size
+	}
+	for _, a := range archives {
+		sort.Strings(a.parts)
+	}
+	return archives
+}
+
+// checkExtract starts extracting the archives of a completed
+// torrent, and deletes them once it is no longer seeding
+func (e *Engine) checkExtract(t *Torrent) {
+	c := e.config
+	if t.ExtractStatus == ExtractDone && c.DeleteArchives && !t.archivesDeleted &&
+		(!c.EnableSeeding || !t.Started) {
+		e.deleteArchives(t)
+	}
+	if !c.ExtractArchives || t.Stream || t.moving || t.extractChecked || !selectedComplete(t) {
+		return
+	}
+	dir := c.DownloadDirectory
+	if located, ok := e.located.Load(t.t.InfoHash()); ok {
+		dir = located.(string)
+	}
+	if c.IncompleteDirectory != "" && dir == c.IncompleteDirectory {
+		//wait for the move
+		return
+	}
+	t.extractChecked = true
+	archives := findArchives(dir, t)
+	if len(archives) == 0 {
+		return
+	}
+	t.archives = archives
+	t.ExtractStatus = ExtractRunning
+	t.ExtractPercent = 0
+	t.ExtractError = ""
+	go e.extractArchives(t, archives)
+}
+
+func (e *Engine) extractArchives(t *Torrent, archives []*archive) {
+	total, done := int64(0), int64(0)
+	for _, a := range archives {
+		total += a.size
+	}
+	progress := func(n int64) {
+		done += n
+		e.mut.Lock()
+		t.ExtractPercent = percent(done, total)
+		e.mut.Unlock()
+	}
+	for _, a := range archives {
+		if err := a.extract(progress); err != nil {
+			log.Printf("Extracting %s failed: %s", a.parts[0], err)
+			e.mut.Lock()
+			t.ExtractStatus = ExtractFailed
+			t.ExtractError = fmt.Sprintf("%s: %s", filepath.Base(a.parts[0]), err)
+			e.mut.Unlock()
+			return
+		}
+	}
+	e.mut.Lock()
+	t.ExtractStatus = ExtractDone
+	t.ExtractPercent = 100
+	e.mut.Unlock()
+}
+
+// deleteArchives removes extracted archives and deselects them, so
+// they are not downloaded again
+func (e *Engine) deleteArchives(t *Torrent) {
+	t.archivesDeleted = true
+	for _, a := range t.archives {
+		for _, p := range a.parts {
+			if err := os.Remove(p); err != nil {
+				log.Printf("Deleting archive failed: %s", err)
+			}
+		}
+		for _, f := range a.files {
+			f.Priority = false
+			if f.f != nil {
+				f.f.SetPriority(torrent.PiecePriorityNone)
+			}
+		}
+	}
+}
+
+// selectedComplete is true when all selected files are complete
+func selectedComplete(t *Torrent) bool {
+	if !t.Loaded {
+		return false
+	}
+	selected := false
+	for _, f := range t.Files {
+		if f == nil || f.f == nil || !f.Priority {
+			continue
+		}
+		if f.f.BytesCompleted() < f.f.Length() {
+			return false
+		}
+		selected = true
+	}
+	return selected
+}
+
+// extract unpacks the archive into its destination folder,
+// reporting the archive bytes read
+func (a *archive) extract(progress func(int64)) error {
+	if err := os.MkdirAll(a.dest, 0755); err != nil {
+		return err
+	}
+	if a.ext == ".zip" {
+		return a.extractZip(progress)
+	}
+	var readers []io.Reader
+	for _, p := range a.parts {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	var r io.Reader = &progressReader{r: io.MultiReader(readers...), progress: progress}
+	switch a.ext {
+	case ".tar.gz", ".tgz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case ".tar.xz", ".txz":
+		xzr, err := xz.NewReader(r)
+		if err != nil {
+			return err
+		}
+		r = xzr
+	case ".tar.bz2", ".tbz2":
+		r = bzip2.NewReader(r)
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		target, err := extractPath(a.dest, h.Name)
+		if err != nil {
+			return err
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = writeFile(target, tr, os.FileMode(h.Mode).Perm())
+		default:
+			//links and devices are skipped
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (a *archive) extractZip(progress func(int64)) error {
+	zr, err := zip.OpenReader(a.parts[0])
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		target, err := extractPath(a.dest, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFile(target, rc, f.Mode().Perm())
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		progress(int64(f.CompressedSize64))
+	}
+	return nil
+}
+
+// extractPath resolves name inside dest, refusing paths which escape
+// it. Archives of a whole directory start with its root, "./".
+func extractPath(dest, name string) (string, error) {
+	dest = filepath.Clean(dest)
+	target := filepath.Join(dest, filepath.FromSlash(name))
+	if target != dest && !strings.HasPrefix(target, dest+string(filepath.Separator)) {
+		return "", fmt.Errorf("Illegal path in archive (%s)", name)
+	}
+	return target, nil
+}
+
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type progressReader struct {
+	r        io.Reader
+	progress func(int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.progress(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tarEntry struct {
+	name string
+	body string //directories have none
+}
+
+func writeTarGz(t *testing.T, path string, entries []tarEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if e.name[len(e.name)-1] == '/' {
+			h.Typeflag, h.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractRootEntry(t *testing.T) {
+	dir := t.TempDir()
+	//like tar -C dir -czf x.tgz .
+	writeTarGz(t, filepath.Join(dir, "x.tgz"), []tarEntry{
+		{name: "./"},
+		{name: "./sub/"},
+		{name: "./sub/a.txt", body: "hello"},
+	})
+	a := &archive{ext: ".tgz", parts: []string{filepath.Join(dir, "x.tgz")}, dest: filepath.Join(dir, "x")}
+	if err := a.extract(func(int64) {}); err != nil {
+		t.Fatalf("extract: %s", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "x", "sub", "a.txt"))
+	if err != nil || string(b) != "hello" {
+		t.Fatalf("extracted file: %q %v", b, err)
+	}
+}
+
+func TestExtractTraversal(t *testing.T) {
+	dir := t.TempDir()
+	writeTarGz(t, filepath.Join(dir, "x.tgz"), []tarEntry{
+		{name: "../evil.txt", body: "evil"},
+	})
+	a := &archive{ext: ".tgz", parts: []string{filepath.Join(dir, "x.tgz")}, dest: filepath.Join(dir, "x")}
+	if err := a.extract(func(int64) {}); err == nil {
+		t.Fatal("expected an error for ../ in the archive")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil.txt")); !os.IsNotExist(err) {
+		t.Fatal("file was written outside the destination")
+	}
+}
+
+func TestExtractPath(t *testing.T) {
+	dest := filepath.FromSlash("/dl/x")
+	for name, ok := range map[string]bool{
+		".":            true,
+		"./":           true,
+		"a/b.txt":      true,
+		"./a/../b.txt": true,
+		"..":           false,
+		"../x2/a.txt":  false,
+		"a/../../b":    false,
+	} {
+		_, err := extractPath(dest, name)
+		if (err == nil) != ok {
+			t.Errorf("extractPath(%q): %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Torrent states, see TorrentFilter
+const (
+	StateStarted  = "started"
+	StateStopped  = "stopped"
+	StateComplete = "complete"
+	StateError    = "error"
+)
+
+// TorrentFilter selects torrents, empty fields match all torrents
+type TorrentFilter struct {
+	Tag      string
+	Category string
+	State    string
+	Name     string //part of the name, ignoring case
+}
+
+func (f TorrentFilter) validate() error {
+	switch f.State {
+	case "", StateStarted, StateStopped, StateComplete, StateError:
+		return nil
+	}
+	return fmt.Errorf("Invalid state (%s)", f.State)
+}
+
+// Match is true when t matches all fields of the filter
+func (f TorrentFilter) Match(t *Torrent) bool {
+	if f.Tag != "" && !contains(t.Tags, f.Tag) {
+		return false
+	}
+	if f.Category != "" && t.Category != f.Category {
+		return false
+	}
+	if f.Name != "" && !strings.Contains(strings.ToLower(t.Name), strings.ToLower(f.Name)) {
+		return false
+	}
+	switch f.State {
+	case StateStarted:
+		return t.Started
+	case StateStopped:
+		return !t.Started
+	case StateComplete:
+		return t.Loaded && t.Percent >= 100
+	case StateError:
+		return t.Error != "" || t.ExtractError != ""
+	}
+	return true
+}
+
+// FindTorrents returns copies of the torrents matching f, sorted by
+// infohash
+func (e *Engine) FindTorrents(f TorrentFilter) ([]*Torrent, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	ts := []*Torrent{}
+	for _, t := range e.ts {
+		if f.Match(t) {
+			ts = append(ts, t.snapshot())
+		}
+	}
+	sort.Slice(ts, func(i, j int) bool {
+		return ts[i].InfoHash < ts[j].InfoHash
+	})
+	return ts, nil
+}
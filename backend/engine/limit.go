@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"context"
+	"sync"
+
+	g "github.com/anacrolix/generics"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+	"github.com/anacrolix/torrent/storage"
+	"golang.org/x/time/rate"
+)
+
+// limits below this still allow whole chunks through at once
+const minLimitBurst = 256 << 10
+
+// requests of peers which disconnect are never read, so at most this
+// many are remembered per torrent
+const maxPeerRequests = 4096
+
+// limitStorage rate limits a single torrent, anacrolix/torrent only
+// limits the whole client. Chunks are written as they are received,
+// so delaying writes slows down the peers sending them. Uploads are
+// read like streams and hashes, so the blocks peers request are
+// recorded (see peerMessage) and only reads of those are delayed by
+// the upload limit. Every torrent is wrapped, so limits can be
+// changed after it is added.
+type limitStorage struct {
+	storage.ClientImpl
+	down     *rate.Limiter
+	up       *rate.Limiter
+	mut      sync.Mutex
+	requests map[peerRequest]int
+}
+
+// peerRequest is a block of a piece requested by a peer
+type peerRequest struct {
+	index, begin, length int
+}
+
+func newLimitStorage(inner storage.ClientImpl, down, up int) *limitStorage {
+	ls := &limitStorage{
+		ClientImpl: inner,
+		down:       rate.NewLimiter(rate.Inf, minLimitBurst),
+		up:         rate.NewLimiter(rate.Inf, minLimitBurst),
+		requests:   map[peerRequest]int{},
+	}
+	ls.setLimits(down, up)
+	return ls
+}
+
+// requested records a block a peer asked for, or cancelled
+func (ls *limitStorage) requested(r peerRequest, cancel bool) {
+	if ls.up.Limit() == rate.Inf {
+		return
+	}
+	ls.mut.Lock()
+	defer ls.mut.Unlock()
+	if cancel {
+		ls.take(r)
+		return
+	}
+	if len(ls.requests) >= maxPeerRequests {
+		ls.requests = map[peerRequest]int{}
+	}
+	ls.requests[r]++
+}
+
+// uploading is true when a read is of a block requested by a peer,
+// each request is read once
+func (ls *limitStorage) uploading(r peerRequest) bool {
+	ls.mut.Lock()
+	defer ls.mut.Unlock()
+	return ls.take(r)
+}
+
+func (ls *limitStorage) take(r peerRequest) bool {
+	n := ls.requests[r]
+	if n == 0 {
+		return false
+	}
+	if n == 1 {
+		delete(ls.requests, r)
+	} else {
+		ls.requests[r] = n - 1
+	}
+	return true
+}
+
+// peerMessage passes the blocks peers request to the limits of their
+// torrent. The client lock is held, so torrents are found in e.uploads
+// rather than e.ts.
+func (e *Engine) peerMessage(pc *torrent.PeerConn, msg *pp.Message) {
+	if msg.Type != pp.Request && msg.Type != pp.Cancel {
+		return
+	}
+	t := pc.Torrent()
+	if t == nil {
+		return
+	}
+	if ls, ok := e.uploads.Load(t.InfoHash()); ok {
+		ls.(*limitStorage).requested(peerRequest{
+			index:  int(msg.Index),
+			begin:  int(msg.Begin),
+			length: int(msg.Length),
+		}, msg.Type == pp.Cancel)
+	}
+}
+
+// setLimits sets the limits in bytes per second, 0 is unlimited
+func (ls *limitStorage) setLimits(down, up int) {
+	setLimit(ls.down, down)
+	setLimit(ls.up, up)
+}
+
+func setLimit(l *rate.Limiter, limit int) {
+	if limit <= 0 {
+		l.SetLimit(rate.Inf)
+		return
+	}
+	burst := limit
+	if burst < minLimitBurst {
+		burst = minLimitBurst
+	}
+	l.SetBurst(burst)
+	l.SetLimit(rate.Limit(limit))
+}
+
+// wait blocks until n bytes are allowed through l
+func wait(l *rate.Limiter, n int) {
+	if l.Limit() == rate.Inf {
+		return
+	}
+	for n > 0 {
+		m := n
+		if m > l.Burst() {
+			m = l.Burst()
+		}
+		l.WaitN(context.Background(), m)
+		n -= m
+	}
+}
+
+func (ls *limitStorage) OpenTorrent(ctx context.Context, info *metainfo.Info, ih metainfo.Hash) (storage.TorrentImpl, error) {
+	ti, err := ls.ClientImpl.OpenTorrent(ctx, info, ih)
+	if err != nil {
+		return ti, err
+	}
+	if piece := ti.Piece; piece != nil {
+		ti.Piece = func(p metainfo.Piece) storage.PieceImpl {
+			return limitPiece{PieceImpl: piece(p), ls: ls, index: p.Index()}
+		}
+	}
+	if piece := ti.PieceWithHash; piece != nil {
+		ti.PieceWithHash = func(p metainfo.Piece, hash g.Option[[]byte]) storage.PieceImpl {
+			return limitPiece{PieceImpl: piece(p, hash), ls: ls, index: p.Index()}
+		}
+	}
+	return ti, nil
+}
+
+type limitPiece struct {
+	storage.PieceImpl
+	ls    *limitStorage
+	index int
+}
+
+func (lp limitPiece) ReadAt(b []byte, off int64) (int, error) {
+	if lp.ls.uploading(peerRequest{lp.index, int(off), len(b)}) {
+		wait(lp.ls.up, len(b))
+	}
+	return lp.PieceImpl.ReadAt(b, off)
+}
+
+func (lp limitPiece) WriteAt(b []byte, off int64) (int, error) {
+	wait(lp.ls.down, len(b))
+	return lp.PieceImpl.WriteAt(b, off)
+}
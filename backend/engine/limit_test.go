@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// completePiece is a complete piece of zeros
+type completePiece struct{}
+
+func (completePiece) ReadAt(b []byte, off int64) (int, error)  { return len(b), nil }
+func (completePiece) WriteAt(b []byte, off int64) (int, error) { return len(b), nil }
+func (completePiece) MarkComplete() error                      { return nil }
+func (completePiece) MarkNotComplete() error                   { return nil }
+func (completePiece) Completion() storage.Completion {
+	return storage.Completion{Complete: true, Ok: true}
+}
+
+func TestLimitUploads(t *testing.T) {
+	ls := newLimitStorage(nil, 0, 1000)
+	lp := limitPiece{PieceImpl: completePiece{}, ls: ls, index: 2}
+	block := make([]byte, 1<<14)
+	//reads take tokens from the upload limiter when they are limited
+	limited := func() bool {
+		before := ls.up.Tokens()
+		lp.ReadAt(block, 1<<14)
+		return before-ls.up.Tokens() > 1<<13
+	}
+
+	if limited() {
+		t.Error("stream or hash read limited")
+	}
+	ls.requested(peerRequest{2, 1 << 14, 1 << 14}, false)
+	if !limited() {
+		t.Error("requested block not limited")
+	}
+	if limited() {
+		t.Error("request limited twice")
+	}
+	ls.requested(peerRequest{1, 1 << 14, 1 << 14}, false)
+	ls.requested(peerRequest{2, 0, 1 << 14}, false)
+	if limited() {
+		t.Error("read limited by the request of another block")
+	}
+	ls.requested(peerRequest{2, 1 << 14, 1 << 14}, false)
+	ls.requested(peerRequest{2, 1 << 14, 1 << 14}, true)
+	if limited() {
+		t.Error("cancelled request limited")
+	}
+
+	for i := 0; i < maxPeerRequests+10; i++ {
+		ls.requested(peerRequest{3, i, 1}, false)
+	}
+	if n := len(ls.requests); n > maxPeerRequests {
+		t.Errorf("%d requests remembered", n)
+	}
+	ls.setLimits(0, 0)
+	ls.requests = map[peerRequest]int{}
+	ls.requested(peerRequest{2, 1 << 14, 1 << 14}, false)
+	if len(ls.requests) > 0 {
+		t.Error("request recorded without an upload limit")
+	}
+}
+
+func TestLimitPeerUploads(t *testing.T) {
+	dir := t.TempDir()
+	mi := testTorrent(t, dir, "data", map[string]int{"a": 1 << 16})
+	e := newTestEngine(t, Config{DownloadDirectory: dir, EnableUpload: true, EnableSeeding: true})
+	spec, _ := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err := e.Add(spec, AddOptions{UploadLimit: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	e.mut.Lock()
+	st := e.ts[spec.InfoHash.HexString()]
+	seeder, ls := st.t, st.storage
+	e.mut.Unlock()
+	seeder.VerifyData()
+	<-seeder.Complete().On()
+
+	config := torrent.NewDefaultClientConfig()
+	config.DataDir = t.TempDir()
+	config.ListenHost = func(string) string { return "127.0.0.1" }
+	config.ListenPort = 0
+	config.NoDHT = true
+	config.DisableUTP = true
+	config.DisableIPv6 = true
+	config.NoDefaultPortForwarding = true
+	leecher, err := torrent.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leecher.Close()
+	lt, err := leecher.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lt.DownloadAll()
+	lt.AddClientPeer(e.client)
+	select {
+	case <-lt.Complete().On():
+	case <-time.After(10 * time.Second):
+		t.Fatal("download timed out")
+	}
+	//the burst lets the whole torrent through, taking about 64 KiB of tokens
+	if used := float64(ls.up.Burst()) - ls.up.Tokens(); used < 1<<15 {
+		t.Fatalf("uploads took %.0f tokens", used)
+	}
+}
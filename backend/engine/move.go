@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// torrentDir is the storage TorrentDirMaker. Torrents are stored in
+// their save path or the download directory. With an incomplete
+// directory, torrents are stored there until they have been moved,
+// unless they are already found in the download directory.
+func (e *Engine) torrentDir(c Config) func(string, *metainfo.Info, metainfo.Hash) string {
+	return func(baseDir string, info *metainfo.Info, ih metainfo.Hash) string {
+		dir := e.downloadDir(ih, c)
+		if c.IncompleteDirectory != "" {
+			_, moved := e.moved.Load(ih)
+			found := exists(filepath.Join(dir, info.BestName())) &&
+				!exists(filepath.Join(c.IncompleteDirectory, info.BestName()))
+			if !moved && !found {
+				dir = c.IncompleteDirectory
+			}
+		}
+		e.located.Store(ih, dir)
+		return dir
+	}
+}
+
+// checkCompleted moves torrents out of the incomplete directory
+// once all of their selected files are complete
+func (e *Engine) checkCompleted(t *Torrent, tt *torrent.Torrent) {
+	dir := e.config.IncompleteDirectory
+	if dir == "" || t.moving || t.moveFailed || t.Stream || !t.Loaded {
+		return
+	}
+	if located, _ := e.located.Load(tt.InfoHash()); located != dir {
+		return
+	}
+	if !selectedComplete(t) {
+		return
+	}
+	t.moving = true
+	go e.moveCompleted(t, tt, e.config)
+}
+
+// moveCompleted drops the torrent, moves its data into the download
+// directory and adds it again, the piece completion database
+// prevents a re-hash so it continues seeding from the new location
+func (e *Engine) moveCompleted(t *Torrent, tt *torrent.Torrent, c Config) {
+	mi := tt.Metainfo()
+	//v1 torrents have no piece layers, an empty map fails to re-add
+	if len(mi.PieceLayers) == 0 {
+		mi.PieceLayers = nil
+	}
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(&mi)
+	if err != nil {
+		e.mut.Lock()
+		t.Error = fmt.Sprintf("Move failed: %s", err)
+		t.moving, t.moveFailed = false, true
+		e.mut.Unlock()
+		return
+	}
+	e.mut.Lock()
+	if t.storage != nil {
+		spec.Storage = t.storage
+	}
+	e.mut.Unlock()
+	name := tt.Info().BestName()
+	tt.Drop()
+	<-tt.Closed()
+	src := filepath.Join(c.IncompleteDirectory, name)
+	dst := filepath.Join(e.downloadDir(tt.InfoHash(), c), name)
+	moveErr := moveAll(src, dst)
+	if moveErr != nil {
+		log.Printf("Move failed: %s", moveErr)
+		e.mut.Lock()
+		t.Error = fmt.Sprintf("Move failed: %s", moveErr)
+		e.mut.Unlock()
+	} else {
+		e.moved.Store(tt.InfoHash(), true)
+	}
+	//re-add, from the original location when the move failed
+	tt2, _, err := e.client.AddTorrentSpec(spec)
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t.moving = false
+	if err != nil {
+		t.Error = fmt.Sprintf("Re-adding after move failed: %s", err)
+		return
+	}
+	e.upsertTorrent(tt2)
+	if moveErr != nil {
+		//the torrent stays in the incomplete directory, starting it
+		//again retries the move
+		t.moveFailed = true
+		return
+	}
+	if t.Started {
+		t.Started = false
+		if err := e.startTorrent(t.InfoHash); err != nil {
+			t.Error = err.Error()
+		}
+	}
+}
+
+// moveAll renames src to dst, falling back to copying when they are
+// on different filesystems. The copy is hidden, so media scanners
+// ignore it, and renamed into place once complete, so dst never holds
+// partial data.
+func moveAll(src, dst string) error {
+	if exists(dst) {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := rename(src, dst); err == nil {
+		return nil
+	}
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".moving")
+	if err := copyAll(src, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := rename(tmp, dst); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// rename is os.Rename, replaced by tests to move across filesystems
+var rename = os.Rename
+
+func copyAll(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
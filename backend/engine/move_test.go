@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestMoveAll(t *testing.T) {
+	dir := t.TempDir()
+	testTorrent(t, dir, "src", map[string]int{"a.mkv": 10, "Subs/a.srt": 5})
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "done", "dst")
+	if err := moveAll(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if exists(src) || !exists(filepath.Join(dst, "Subs", "a.srt")) {
+		t.Fatal("not moved")
+	}
+	testTorrent(t, dir, "src", map[string]int{"a.mkv": 10})
+	if err := moveAll(src, dst); err == nil || !exists(src) {
+		t.Errorf("moved over an existing destination (%v)", err)
+	}
+}
+
+func TestMoveAllCopy(t *testing.T) {
+	dir := t.TempDir()
+	testTorrent(t, dir, "src", map[string]int{"a.mkv": 10, "Subs/a.srt": 5})
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "done", "dst")
+	//the first rename fails as it would across filesystems
+	renamed := []string{}
+	rename = func(from, to string) error {
+		renamed = append(renamed, from)
+		if from == src {
+			return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EXDEV}
+		}
+		return os.Rename(from, to)
+	}
+	defer func() { rename = os.Rename }()
+	if err := moveAll(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(renamed) != 2 || !strings.HasPrefix(filepath.Base(renamed[1]), ".") || filepath.Dir(renamed[1]) != filepath.Dir(dst) {
+		t.Errorf("copied through %q, want a hidden sibling of the destination", renamed)
+	}
+	if b, err := os.ReadFile(filepath.Join(dst, "Subs", "a.srt")); err != nil || len(b) != 5 {
+		t.Errorf("copied %d bytes (%v)", len(b), err)
+	}
+	if exists(src) || exists(renamed[1]) {
+		t.Error("source or copy left behind")
+	}
+}
+
+func TestTorrentDir(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{DownloadDirectory: filepath.Join(dir, "done"), IncompleteDirectory: filepath.Join(dir, "incomplete")}
+	e := New()
+	info := &metainfo.Info{Name: "show"}
+	ih := metainfo.Hash{1}
+	located := func(want string) {
+		t.Helper()
+		if got := e.torrentDir(c)("", info, ih); got != want {
+			t.Errorf("stored in %s, want %s", got, want)
+		}
+		if got, _ := e.located.Load(ih); got != want {
+			t.Errorf("located in %v, want %s", got, want)
+		}
+	}
+	located(c.IncompleteDirectory)
+	//moved before a restart
+	os.MkdirAll(filepath.Join(c.DownloadDirectory, "show"), 0755)
+	located(c.DownloadDirectory)
+	//a torrent of the same name is still incomplete
+	os.MkdirAll(filepath.Join(c.IncompleteDirectory, "show"), 0755)
+	located(c.IncompleteDirectory)
+	e.moved.Store(ih, true)
+	located(c.DownloadDirectory)
+	e.savePaths.Store(ih, filepath.Join(dir, "saved"))
+	located(filepath.Join(dir, "saved"))
+}
+
+func TestMoveCompleted(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{DownloadDirectory: filepath.Join(dir, "done"), IncompleteDirectory: filepath.Join(dir, "incomplete")}
+	mi := testTorrent(t, c.IncompleteDirectory, "show", map[string]int{"a.mkv": 1 << 14})
+	//the destination is taken, the move fails
+	os.MkdirAll(filepath.Join(c.DownloadDirectory, "show"), 0755)
+	e := newTestEngine(t, c)
+	spec, _ := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err := e.Add(spec, AddOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	e.mut.Lock()
+	st := e.ts[spec.InfoHash.HexString()]
+	tt := st.t
+	e.mut.Unlock()
+	tt.VerifyData()
+	<-tt.Complete().On()
+	//waitMoved polls the torrents until the move has finished
+	waitMoved := func() (errMsg string, failed bool) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			e.GetTorrents()
+			e.mut.Lock()
+			errMsg, failed, moving := st.Error, st.moveFailed, st.moving
+			moved := exists(filepath.Join(c.DownloadDirectory, "show", "a.mkv"))
+			e.mut.Unlock()
+			if !moving && (failed || moved) {
+				return errMsg, failed
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatal("move timed out")
+		return "", false
+	}
+	errMsg, failed := waitMoved()
+	if !failed || !strings.HasPrefix(errMsg, "Move failed") {
+		t.Fatalf("failed move reported %q", errMsg)
+	}
+	if !exists(filepath.Join(c.IncompleteDirectory, "show", "a.mkv")) {
+		t.Fatal("data lost by the failed move")
+	}
+	//not retried until started again
+	e.GetTorrents()
+	e.mut.Lock()
+	moving := st.moving
+	e.mut.Unlock()
+	if moving {
+		t.Fatal("failed move retried")
+	}
+
+	os.Remove(filepath.Join(c.DownloadDirectory, "show"))
+	if err := e.StartTorrent(spec.InfoHash.HexString()); err != nil {
+		t.Fatal(err)
+	}
+	if errMsg, failed := waitMoved(); failed || errMsg != "" {
+		t.Fatalf("retried move failed: %s", errMsg)
+	}
+	if exists(filepath.Join(c.IncompleteDirectory, "show")) {
+		t.Error("data left in the incomplete directory")
+	}
+}
@@ -0,0 +1,280 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// PartExtension is appended to incomplete files
+const PartExtension = ".part"
+
+// partStorage stores torrent data in files, like the default file
+// storage, but incomplete files are named with PartExtension and
+// renamed as soon as all of their pieces are complete
+type partStorage struct {
+	baseDir    string
+	dirMaker   storage.TorrentDirFilePathMaker
+	completion storage.PieceCompletion
+}
+
+func newPartStorage(baseDir string, dirMaker storage.TorrentDirFilePathMaker, pc storage.PieceCompletion) storage.ClientImplCloser {
+	return &partStorage{baseDir: baseDir, dirMaker: dirMaker, completion: pc}
+}
+
+func (ps *partStorage) Close() error {
+	return ps.completion.Close()
+}
+
+type partTorrent struct {
+	ps    *partStorage
+	ih    metainfo.Hash
+	info  *metainfo.Info
+	files []*partFile
+}
+
+// partFile is read and written under a read lock, so only renaming
+// it waits for other I/O and only on this file
+type partFile struct {
+	path     string //final path
+	offset   int64
+	length   int64
+	mut      sync.RWMutex
+	complete bool         //renamed, guarded by mut
+	size     atomic.Int64 //bytes on disk, so completion needs no stat
+}
+
+// current returns the path the file is stored at, with f.mut held
+func (f *partFile) current() string {
+	if f.complete {
+		return f.path
+	}
+	return f.path + PartExtension
+}
+
+// grow records that the file holds at least n bytes
+func (f *partFile) grow(n int64) {
+	for {
+		size := f.size.Load()
+		if size >= n || f.size.CompareAndSwap(size, n) {
+			return
+		}
+	}
+}
+
+func (ps *partStorage) OpenTorrent(_ context.Context, info *metainfo.Info, ih metainfo.Hash) (storage.TorrentImpl, error) {
+	dir := ps.baseDir
+	if ps.dirMaker != nil {
+		dir = ps.dirMaker(ps.baseDir, info, ih)
+	}
+	pt := &partTorrent{ps: ps, ih: ih, info: info}
+	offset := int64(0)
+	for _, fi := range info.UpvertedFiles() {
+		parts := []string{}
+		if info.BestName() != metainfo.NoName {
+			parts = append(parts, info.BestName())
+		}
+		path := filepath.Join(dir, filepath.Join(append(parts, fi.BestPath()...)...))
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return storage.TorrentImpl{}, fmt.Errorf("File path %q is outside %q", path, dir)
+		}
+		f := &partFile{path: path, offset: offset, length: fi.Length}
+		//a complete file has been renamed already
+		if s, err := os.Stat(path); err == nil && s.Size() == f.length {
+			f.complete = true
+			f.size.Store(f.length)
+		} else if s, err := os.Stat(path + PartExtension); err == nil {
+			f.size.Store(s.Size())
+		}
+		if f.length == 0 {
+			f.complete = true
+			if err := storage.CreateNativeZeroLengthFile(path); err != nil {
+				return storage.TorrentImpl{}, err
+			}
+		}
+		pt.files = append(pt.files, f)
+		offset += fi.Length
+	}
+	//completed before the rename, e.g. when stopped in between
+	for _, f := range pt.files {
+		if f.complete || f.size.Load() != f.length || !pt.piecesComplete(f) {
+			continue
+		}
+		if err := os.Rename(f.path+PartExtension, f.path); err != nil {
+			return storage.TorrentImpl{}, err
+		}
+		f.complete = true
+	}
+	return storage.TorrentImpl{
+		Piece: func(p metainfo.Piece) storage.PieceImpl {
+			return &partPiece{pt: pt, index: p.Index(), offset: p.Offset(), length: p.Length()}
+		},
+		Close: func() error { return nil },
+	}, nil
+}
+
+// each calls fn with the file sections covering [off, off+n)
+func (pt *partTorrent) each(off, n int64, fn func(f *partFile, fileOff, n int64) error) error {
+	for _, f := range pt.files {
+		if n <= 0 {
+			break
+		}
+		if f.length == 0 || off >= f.offset+f.length || off < f.offset {
+			continue
+		}
+		l := f.offset + f.length - off
+		if l > n {
+			l = n
+		}
+		if err := fn(f, off-f.offset, l); err != nil {
+			return err
+		}
+		off += l
+		n -= l
+	}
+	return nil
+}
+
+func (pt *partTorrent) readAt(b []byte, off int64) (int, error) {
+	read := 0
+	err := pt.each(off, int64(len(b)), func(f *partFile, fileOff, n int64) error {
+		f.mut.RLock()
+		defer f.mut.RUnlock()
+		file, err := os.Open(f.current())
+		if os.IsNotExist(err) {
+			//treated as a short file
+			return io.EOF
+		} else if err != nil {
+			return err
+		}
+		defer file.Close()
+		m, err := file.ReadAt(b[read:read+int(n)], fileOff)
+		read += m
+		if err == io.EOF && int64(m) == n {
+			err = nil
+		}
+		return err
+	})
+	if err == nil && read < len(b) {
+		err = io.EOF
+	}
+	return read, err
+}
+
+func (pt *partTorrent) writeAt(b []byte, off int64) (int, error) {
+	written := 0
+	err := pt.each(off, int64(len(b)), func(f *partFile, fileOff, n int64) error {
+		f.mut.RLock()
+		defer f.mut.RUnlock()
+		path := f.current()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		m, err := file.WriteAt(b[written:written+int(n)], fileOff)
+		written += m
+		f.grow(fileOff + int64(m))
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+	return written, err
+}
+
+// finish renames the files of a piece which are now complete
+func (pt *partTorrent) finish(index int, pieceLength int64) error {
+	return pt.each(int64(index)*pt.info.PieceLength, pieceLength, func(f *partFile, _, _ int64) error {
+		f.mut.Lock()
+		defer f.mut.Unlock()
+		if f.complete || !pt.piecesComplete(f) {
+			return nil
+		}
+		if err := os.Rename(f.path+PartExtension, f.path); err != nil {
+			return err
+		}
+		f.complete = true
+		return nil
+	})
+}
+
+// piecesComplete reports whether every piece of f is complete
+func (pt *partTorrent) piecesComplete(f *partFile) bool {
+	first := int(f.offset / pt.info.PieceLength)
+	last := int((f.offset + f.length - 1) / pt.info.PieceLength)
+	for i := first; i <= last; i++ {
+		c, err := pt.ps.completion.Get(metainfo.PieceKey{InfoHash: pt.ih, Index: i})
+		if err != nil || !c.Complete {
+			return false
+		}
+	}
+	return true
+}
+
+type partPiece struct {
+	pt     *partTorrent
+	index  int
+	offset int64
+	length int64
+}
+
+func (pp *partPiece) key() metainfo.PieceKey {
+	return metainfo.PieceKey{InfoHash: pp.pt.ih, Index: pp.index}
+}
+
+func (pp *partPiece) ReadAt(b []byte, off int64) (int, error) {
+	if off+int64(len(b)) > pp.length {
+		b = b[:pp.length-off]
+	}
+	return pp.pt.readAt(b, pp.offset+off)
+}
+
+func (pp *partPiece) WriteAt(b []byte, off int64) (int, error) {
+	if off+int64(len(b)) > pp.length {
+		return 0, fmt.Errorf("Write beyond piece")
+	}
+	return pp.pt.writeAt(b, pp.offset+off)
+}
+
+func (pp *partPiece) MarkComplete() error {
+	if err := pp.pt.ps.completion.Set(pp.key(), true); err != nil {
+		return err
+	}
+	return pp.pt.finish(pp.index, pp.length)
+}
+
+func (pp *partPiece) MarkNotComplete() error {
+	return pp.pt.ps.completion.Set(pp.key(), false)
+}
+
+func (pp *partPiece) Completion() storage.Completion {
+	c, err := pp.pt.ps.completion.Get(pp.key())
+	if err != nil {
+		return storage.Completion{Err: err}
+	}
+	if !c.Complete {
+		return c
+	}
+	//allegedly complete, check the files hold the data
+	pp.pt.each(pp.offset, pp.length, func(f *partFile, fileOff, n int64) error {
+		if f.size.Load() < fileOff+n {
+			c.Complete = false
+		}
+		return nil
+	})
+	if !c.Complete {
+		pp.pt.ps.completion.Set(pp.key(), false)
+	}
+	return c
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// TestPartFileRenamedOnOpen opens a torrent whose first file has all
+// its pieces complete but was not renamed yet
+func TestPartFileRenamedOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	info := &metainfo.Info{
+		Name:        "show",
+		PieceLength: 16,
+		Files: []metainfo.FileInfo{
+			{Path: []string{"a.mkv"}, Length: 32}, //pieces 0 and 1
+			{Path: []string{"b.mkv"}, Length: 20}, //pieces 2 and 3
+		},
+	}
+	ih := metainfo.Hash{1}
+	files := map[string]int{"a.mkv": 32, "b.mkv": 20}
+	for name, length := range files {
+		path := filepath.Join(dir, "show", name+PartExtension)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, bytes.Repeat([]byte{'x'}, length), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pc := storage.NewMapPieceCompletion()
+	for _, i := range []int{0, 1, 2} {
+		pc.Set(metainfo.PieceKey{InfoHash: ih, Index: i}, true)
+	}
+
+	ps := newPartStorage(dir, nil, pc)
+	if _, err := ps.OpenTorrent(context.Background(), info, ih); err != nil {
+		t.Fatal(err)
+	}
+	for path, exists := range map[string]bool{
+		"a.mkv":                 true,
+		"a.mkv" + PartExtension: false,
+		"b.mkv":                 false,
+		"b.mkv" + PartExtension: true,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, "show", path)); (err == nil) != exists {
+			t.Errorf("%s exists: %v, want %v", path, err == nil, exists)
+		}
+	}
+}
+
+// openPartTorrent opens a torrent of two files, a.mkv with pieces 0
+// and 1 and b.mkv with pieces 2 and 3, returning its pieces by index
+func openPartTorrent(t *testing.T, dir string, pc storage.PieceCompletion) (*partTorrent, func(int) storage.PieceImpl) {
+	info := &metainfo.Info{
+		Name:        "show",
+		PieceLength: 16,
+		Files: []metainfo.FileInfo{
+			{Path: []string{"a.mkv"}, Length: 32},
+			{Path: []string{"b.mkv"}, Length: 20},
+		},
+		Pieces: make([]byte, 4*20),
+	}
+	ti, err := newPartStorage(dir, nil, pc).OpenTorrent(context.Background(), info, metainfo.Hash{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	piece := func(i int) storage.PieceImpl { return ti.Piece(info.Piece(i)) }
+	return piece(0).(*partPiece).pt, piece
+}
+
+func TestPartFileCompletion(t *testing.T) {
+	dir := t.TempDir()
+	//a short file left from an earlier run
+	os.MkdirAll(filepath.Join(dir, "show"), 0755)
+	os.WriteFile(filepath.Join(dir, "show", "a.mkv"+PartExtension), make([]byte, 10), 0644)
+	pc := storage.NewMapPieceCompletion()
+	pc.Set(metainfo.PieceKey{InfoHash: metainfo.Hash{1}, Index: 0}, true)
+	_, piece := openPartTorrent(t, dir, pc)
+
+	if piece(0).Completion().Complete {
+		t.Fatal("piece 0 complete with 10 of its 16 bytes on disk")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := piece(i).WriteAt(bytes.Repeat([]byte{'a'}, 16), 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := piece(i).MarkComplete(); err != nil {
+			t.Fatal(err)
+		}
+		if !piece(i).Completion().Complete {
+			t.Fatalf("piece %d not complete after writing it", i)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "show", "a.mkv")); err != nil {
+		t.Fatalf("complete file not renamed: %s", err)
+	}
+	b := make([]byte, 16)
+	if n, err := piece(1).ReadAt(b, 0); n != 16 || err != nil || b[0] != 'a' {
+		t.Fatalf("read %d %q after rename (%v)", n, b, err)
+	}
+}
+
+// TestPartFileLocks writes one file while another is being renamed
+func TestPartFileLocks(t *testing.T) {
+	pt, piece := openPartTorrent(t, t.TempDir(), storage.NewMapPieceCompletion())
+	a := pt.files[0]
+	a.mut.Lock()
+	done := make(chan error)
+	go func() {
+		_, err := piece(2).WriteAt(bytes.Repeat([]byte{'b'}, 16), 0)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("writing b.mkv waited for a.mkv")
+	}
+	a.mut.Unlock()
+	if size := pt.files[1].size.Load(); size != 16 {
+		t.Errorf("b.mkv size %d, want 16", size)
+	}
+}
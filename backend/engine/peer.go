@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Peer is a connected peer of a torrent
+type Peer struct {
+	Address      string
+	Client       string
+	Network      string
+	Source       string
+	Encryption   string //"rc4", "obfuscated" or "plaintext"
+	DownloadRate float32
+}
+
+// GetPeers returns the connected peers of a torrent
+func (e *Engine) GetPeers(infohash string) ([]*Peer, error) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	t, err := e.getTorrent(infohash)
+	if err != nil {
+		return nil, err
+	}
+	peers := []*Peer{}
+	if t.t == nil {
+		return peers, nil
+	}
+	for _, pc := range t.t.PeerConns() {
+		client, _ := pc.PeerClientName.Load().(string)
+		peers = append(peers, &Peer{
+			Address:      fmt.Sprint(pc.RemoteAddr),
+			Client:       client,
+			Network:      pc.Network,
+			Source:       string(pc.Discovery),
+			Encryption:   peerEncryption(pc),
+			DownloadRate: float32(pc.DownloadRate()),
+		})
+	}
+	return peers, nil
+}
+
+var peerFlagsRe = regexp.MustCompile(`flags=(\S*)`)
+
+// peerEncryption reads the connection flags from PeerConn.String.
+// anacrolix/torrent keeps the crypto method and header obfuscation
+// unexported, the flags ("E" rc4, "e" obfuscated) are the only place
+// they show. TestPeerEncryption handshakes with each policy, so a
+// dependency update that changes the format fails the tests.
+func peerEncryption(pc *torrent.PeerConn) string {
+	m := peerFlagsRe.FindStringSubmatch(pc.String())
+	if m == nil {
+		return "plaintext"
+	}
+	for _, f := range strings.Split(m[1], ",") {
+		switch f {
+		case "E":
+			return "rc4"
+		case "e":
+			return "obfuscated"
+		}
+	}
+	return "plaintext"
+}
@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// TestPeerEncryption connects two clients with each policy, pinning
+// the connection flags peerEncryption parses
+func TestPeerEncryption(t *testing.T) {
+	for policy, want := range map[string]string{
+		EncryptionRequire: "rc4",
+		EncryptionPrefer:  "obfuscated",
+		EncryptionDisable: "plaintext",
+	} {
+		if got := handshakeEncryption(t, policy); got != want {
+			t.Errorf("%s: encryption %s, want %s", policy, got, want)
+		}
+	}
+}
+
+// handshakeEncryption seeds a torrent from a fresh client, downloads
+// it with another and returns the leecher's view of the connection
+func handshakeEncryption(t *testing.T, policy string) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data"), make([]byte, 1<<14), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info := metainfo.Info{PieceLength: 1 << 14}
+	if err := info.BuildFromFilePath(filepath.Join(dir, "data")); err != nil {
+		t.Fatal(err)
+	}
+	mi := &metainfo.MetaInfo{}
+	var err error
+	if mi.InfoBytes, err = bencode.Marshal(info); err != nil {
+		t.Fatal(err)
+	}
+
+	newClient := func(dataDir string, handshake func(*torrent.PeerConn, torrent.InfoHash)) *torrent.Client {
+		config := torrent.NewDefaultClientConfig()
+		config.DataDir = dataDir
+		config.ListenHost = func(string) string { return "127.0.0.1" }
+		config.ListenPort = 0
+		config.NoDHT = true
+		config.DisableUTP = true
+		config.DisableIPv6 = true
+		config.NoDefaultPortForwarding = true
+		config.Seed = true
+		config.Callbacks.CompletedHandshake = handshake
+		applyEncryption(config, policy)
+		c, err := torrent.NewClient(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	got := make(chan string, 1)
+	seeder := newClient(dir, nil)
+	defer seeder.Close()
+	//the leecher has no data yet, so it dials the seeder
+	leecher := newClient(t.TempDir(), func(pc *torrent.PeerConn, _ torrent.InfoHash) {
+		select {
+		case got <- peerEncryption(pc):
+		default:
+		}
+	})
+	defer leecher.Close()
+	st, err := seeder.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the seeder turns connections away until its data is verified
+	st.VerifyData()
+	select {
+	case <-st.Complete().On():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("%s: seeder data not verified", policy)
+	}
+	tt, err := leecher.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt.DownloadAll()
+	tt.AddClientPeer(seeder)
+
+	select {
+	case encryption := <-got:
+		return encryption
+	case <-time.After(10 * time.Second):
+		t.Fatalf("%s: no connection", policy)
+		return ""
+	}
+}
@@ -0,0 +1,263 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	alog "github.com/anacrolix/log"
+	"github.com/anacrolix/upnp"
+)
+
+const (
+	portMapLease       = 1 * time.Hour
+	portMapRenew       = portMapLease / 2
+	portMapRetry       = 5 * time.Minute
+	portMapDescription = "Remote Torrent"
+	natpmpPort         = 5351
+)
+
+// portMapper maps the incoming port on the router using UPnP,
+// falling back to NAT-PMP, and renews the mapping until closed
+type portMapper struct {
+	mut      sync.Mutex
+	status   string //"disabled", "mapping", "mapped" or "failed"
+	protocol string //"upnp" or "natpmp"
+	external string
+	err      string
+	unmap    func()
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	//find the gateways, UPnP discovery and the default route when nil
+	discover func() []upnp.Device
+	gateway  func() (*net.UDPAddr, error) //NAT-PMP server
+}
+
+// start maps port in the background until close is called
+func (pm *portMapper) start(port int) {
+	pm.mut.Lock()
+	pm.status, pm.protocol, pm.external, pm.err = "mapping", "", "", ""
+	pm.stop = make(chan struct{})
+	stop := pm.stop
+	pm.mut.Unlock()
+	pm.wg.Add(1)
+	go func() {
+		defer pm.wg.Done()
+		for {
+			wait := portMapRenew
+			if err := pm.mapPort(port); err != nil {
+				log.Printf("Port mapping failed: %s", err)
+				wait = portMapRetry
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// close stops renewing and removes the current mapping
+func (pm *portMapper) close() {
+	pm.mut.Lock()
+	stop := pm.stop
+	pm.stop = nil
+	pm.mut.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	pm.wg.Wait()
+	pm.mut.Lock()
+	defer pm.mut.Unlock()
+	if pm.unmap != nil {
+		pm.unmap()
+		pm.unmap = nil
+	}
+	pm.status, pm.protocol, pm.external, pm.err = "disabled", "", "", ""
+}
+
+func (pm *portMapper) mapPort(port int) error {
+	protocol, external, unmap, err := pm.mapUPnP(port)
+	if err != nil {
+		var perr error
+		protocol, external, unmap, perr = pm.mapNATPMP(port)
+		if perr != nil {
+			err = fmt.Errorf("upnp: %s, natpmp: %s", err, perr)
+		} else {
+			err = nil
+		}
+	}
+	pm.mut.Lock()
+	defer pm.mut.Unlock()
+	if err != nil {
+		pm.status, pm.err = "failed", err.Error()
+		return err
+	}
+	pm.status, pm.protocol, pm.external, pm.err = "mapped", protocol, external, ""
+	pm.unmap = unmap
+	return nil
+}
+
+func (pm *portMapper) stats(s *Stats) {
+	pm.mut.Lock()
+	defer pm.mut.Unlock()
+	s.PortMapStatus = pm.status
+	if s.PortMapStatus == "" {
+		s.PortMapStatus = "disabled"
+	}
+	s.PortMapProtocol = pm.protocol
+	s.ExternalAddress = pm.external
+	s.PortMapError = pm.err
+}
+
+// mapUPnP maps port for TCP and UDP on every discovered gateway
+func (pm *portMapper) mapUPnP(port int) (string, string, func(), error) {
+	var devices []upnp.Device
+	if pm.discover != nil {
+		devices = pm.discover()
+	} else {
+		devices = upnp.Discover(0, 3*time.Second, alog.Default)
+	}
+	if len(devices) == 0 {
+		return "", "", nil, errors.New("no gateway found")
+	}
+	var mapped []upnp.Device
+	external := ""
+	var lastErr error
+	for _, d := range devices {
+		if _, err := d.AddPortMapping(upnp.TCP, port, port, portMapDescription, portMapLease); err != nil {
+			lastErr = err
+			continue
+		}
+		if _, err := d.AddPortMapping(upnp.UDP, port, port, portMapDescription, portMapLease); err != nil {
+			lastErr = err
+		}
+		mapped = append(mapped, d)
+		if ip, err := d.GetExternalIPAddress(); err == nil && ip != nil && external == "" {
+			external = net.JoinHostPort(ip.String(), fmt.Sprint(port))
+		}
+	}
+	if len(mapped) == 0 {
+		return "", "", nil, lastErr
+	}
+	unmap := func() {
+		for _, d := range mapped {
+			d.DeletePortMapping(upnp.TCP, port)
+			d.DeletePortMapping(upnp.UDP, port)
+		}
+	}
+	return "upnp", external, unmap, nil
+}
+
+// mapNATPMP maps port for TCP and UDP on the default gateway
+// using NAT-PMP (RFC 6886)
+func (pm *portMapper) mapNATPMP(port int) (string, string, func(), error) {
+	gateway := pm.gateway
+	if gateway == nil {
+		gateway = natpmpGateway
+	}
+	addr, err := gateway()
+	if err != nil {
+		return "", "", nil, err
+	}
+	//opcode 0 requests the external address
+	resp, err := natpmpRequest(addr, []byte{0, 0}, 12)
+	if err != nil {
+		return "", "", nil, err
+	}
+	ip := net.IP(resp[8:12])
+	external := 0
+	for _, op := range []byte{1, 2} { //udp, tcp
+		resp, err := natpmpRequest(addr, natpmpMapRequest(op, port, port, portMapLease), 16)
+		if err != nil {
+			return "", "", nil, err
+		}
+		external = int(binary.BigEndian.Uint16(resp[10:12]))
+	}
+	unmap := func() {
+		for _, op := range []byte{1, 2} {
+			natpmpRequest(addr, natpmpMapRequest(op, port, 0, 0), 16)
+		}
+	}
+	return "natpmp", net.JoinHostPort(ip.String(), fmt.Sprint(external)), unmap, nil
+}
+
+func natpmpMapRequest(op byte, internal, external int, lifetime time.Duration) []byte {
+	req := make([]byte, 12)
+	req[1] = op
+	binary.BigEndian.PutUint16(req[4:6], uint16(internal))
+	binary.BigEndian.PutUint16(req[6:8], uint16(external))
+	binary.BigEndian.PutUint32(req[8:12], uint32(lifetime/time.Second))
+	return req
+}
+
+func natpmpRequest(addr *net.UDPAddr, req []byte, size int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	resp := make([]byte, 16)
+	//retry with a doubling timeout, as per the RFC (shortened)
+	timeout := 250 * time.Millisecond
+	for i := 0; i < 4; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, err := conn.Read(resp)
+		timeout *= 2
+		if err != nil {
+			continue
+		}
+		if n < size || resp[1] != req[1]+128 {
+			return nil, errors.New("invalid response")
+		}
+		if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+			return nil, fmt.Errorf("result code %d", code)
+		}
+		return resp[:n], nil
+	}
+	return nil, errors.New("no response from gateway")
+}
+
+func natpmpGateway() (*net.UDPAddr, error) {
+	gw, err := defaultGateway()
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: gw, Port: natpmpPort}, nil
+}
+
+// defaultGateway reads the IPv4 default route, only linux is supported
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, errors.New("default gateway unknown")
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		//little endian
+		return net.IPv4(b[3], b[2], b[1], b[0]), nil
+	}
+	return nil, errors.New("no default route")
+}
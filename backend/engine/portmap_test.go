@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/upnp"
+)
+
+// fakeGateway is an IGD which records its port mappings
+type fakeGateway struct {
+	mut      sync.Mutex
+	mappings map[upnp.Protocol]int
+	fail     bool
+}
+
+func (g *fakeGateway) ID() string                { return "fake" }
+func (g *fakeGateway) GetLocalIPAddress() net.IP { return net.IPv4(192, 168, 1, 1) }
+
+func (g *fakeGateway) AddPortMapping(protocol upnp.Protocol, internal, external int, description string, duration time.Duration) (int, error) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	if g.fail {
+		return 0, errors.New("mapping refused")
+	}
+	g.mappings[protocol] = external
+	return external, nil
+}
+
+func (g *fakeGateway) DeletePortMapping(protocol upnp.Protocol, external int) error {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	delete(g.mappings, protocol)
+	return nil
+}
+
+func (g *fakeGateway) GetExternalIPAddress() (net.IP, error) {
+	return net.IPv4(203, 0, 113, 1), nil
+}
+
+// natpmpServer answers NAT-PMP requests on a local port, mapping
+// internal ports to external ports 1000 higher
+type natpmpServer struct {
+	conn     *net.UDPConn
+	mut      sync.Mutex
+	mappings map[byte]int //by opcode, 1 udp and 2 tcp
+}
+
+func newNATPMPServer(t *testing.T) *natpmpServer {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &natpmpServer{conn: conn, mappings: map[byte]int{}}
+	go s.serve()
+	t.Cleanup(func() { conn.Close() })
+	return s
+}
+
+func (s *natpmpServer) serve() {
+	b := make([]byte, 64)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(b)
+		if err != nil {
+			return
+		}
+		if n < 2 {
+			continue
+		}
+		op := b[1]
+		resp := make([]byte, 16)
+		resp[1] = op + 128
+		binary.BigEndian.PutUint32(resp[4:8], 1) //seconds since start of epoch
+		switch {
+		case op == 0:
+			copy(resp[8:12], net.IPv4(198, 51, 100, 7).To4())
+			resp = resp[:12]
+		case (op == 1 || op == 2) && n >= 12:
+			internal := int(binary.BigEndian.Uint16(b[4:6]))
+			lifetime := binary.BigEndian.Uint32(b[8:12])
+			s.mut.Lock()
+			if lifetime == 0 {
+				delete(s.mappings, op)
+			} else {
+				s.mappings[op] = internal + 1000
+			}
+			s.mut.Unlock()
+			copy(resp[8:10], b[4:6])
+			binary.BigEndian.PutUint16(resp[10:12], uint16(internal+1000))
+			copy(resp[12:16], b[8:12])
+		default:
+			binary.BigEndian.PutUint16(resp[2:4], 5) //unsupported opcode
+		}
+		s.conn.WriteToUDP(resp, addr)
+	}
+}
+
+func (s *natpmpServer) mapped() int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return len(s.mappings)
+}
+
+// waitMapped waits for the first mapping attempt of pm
+func waitMapped(t *testing.T, pm *portMapper) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		pm.mut.Lock()
+		status := pm.status
+		pm.mut.Unlock()
+		if status != "mapping" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("port mapping timed out")
+}
+
+func TestPortMapUPnP(t *testing.T) {
+	gw := &fakeGateway{mappings: map[upnp.Protocol]int{}}
+	pm := &portMapper{
+		discover: func() []upnp.Device { return []upnp.Device{gw} },
+		gateway: func() (*net.UDPAddr, error) {
+			t.Error("NAT-PMP used although UPnP succeeded")
+			return nil, errors.New("unused")
+		},
+	}
+	pm.start(50123)
+	waitMapped(t, pm)
+	s := &Stats{}
+	pm.stats(s)
+	if s.PortMapStatus != "mapped" || s.PortMapProtocol != "upnp" || s.ExternalAddress != "203.0.113.1:50123" {
+		t.Fatalf("unexpected mapping %+v", s)
+	}
+	gw.mut.Lock()
+	if gw.mappings[upnp.TCP] != 50123 || gw.mappings[upnp.UDP] != 50123 {
+		t.Errorf("gateway mappings %v", gw.mappings)
+	}
+	gw.mut.Unlock()
+
+	pm.close()
+	if len(gw.mappings) > 0 {
+		t.Errorf("mappings left after close %v", gw.mappings)
+	}
+	pm.stats(s)
+	if s.PortMapStatus != "disabled" {
+		t.Errorf("status %s after close", s.PortMapStatus)
+	}
+}
+
+func TestPortMapNATPMP(t *testing.T) {
+	server := newNATPMPServer(t)
+	//the UPnP gateway refuses, NAT-PMP is the fallback
+	gw := &fakeGateway{mappings: map[upnp.Protocol]int{}, fail: true}
+	pm := &portMapper{
+		discover: func() []upnp.Device { return []upnp.Device{gw} },
+		gateway: func() (*net.UDPAddr, error) {
+			return server.conn.LocalAddr().(*net.UDPAddr), nil
+		},
+	}
+	pm.start(50123)
+	waitMapped(t, pm)
+	s := &Stats{}
+	pm.stats(s)
+	if s.PortMapStatus != "mapped" || s.PortMapProtocol != "natpmp" || s.ExternalAddress != "198.51.100.7:51123" {
+		t.Fatalf("unexpected mapping %+v", s)
+	}
+	if n := server.mapped(); n != 2 {
+		t.Errorf("%d mappings, want udp and tcp", n)
+	}
+
+	pm.close()
+	if n := server.mapped(); n != 0 {
+		t.Errorf("%d mappings left after close", n)
+	}
+}
+
+func TestPortMapFailed(t *testing.T) {
+	pm := &portMapper{
+		discover: func() []upnp.Device { return nil },
+		gateway: func() (*net.UDPAddr, error) {
+			return nil, errors.New("no default route")
+		},
+	}
+	pm.start(50123)
+	waitMapped(t, pm)
+	s := &Stats{}
+	pm.stats(s)
+	if s.PortMapStatus != "failed" || !strings.Contains(s.PortMapError, "no gateway found") || !strings.Contains(s.PortMapError, "no default route") {
+		t.Fatalf("unexpected status %+v", s)
+	}
+	pm.close()
+}
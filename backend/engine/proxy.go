@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"golang.org/x/net/proxy"
+)
+
+// ParseProxyURL validates a proxy URL, supported schemes are
+// socks5, socks5h, http and https
+func ParseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid proxy URL: %s", err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http", "https":
+	default:
+		return nil, fmt.Errorf("Unsupported proxy scheme (%s)", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Proxy URL is missing a host")
+	}
+	return u, nil
+}
+
+// applyProxy routes peer connections and tracker announces
+// through the proxy. Outgoing TCP peer connections are dialed via
+// the proxy; when only is set, anything that cannot be proxied
+// (incoming connections, uTP, DHT and UDP trackers) is refused.
+// It returns whether incoming TCP connections should still be
+// accepted, see listenPeers.
+func applyProxy(config *torrent.ClientConfig, u *url.URL, only bool) (listenTCP bool) {
+	config.HTTPProxy = http.ProxyURL(u)
+	listenTCP = !config.DisableTCP && !only
+	//the builtin tcp socket would also dial peers directly
+	config.DisableTCP = true
+	if only {
+		config.DisableUTP = true
+		config.NoDHT = true
+		config.AcceptPeerConnections = false
+		config.TrackerListenPacket = func(network, addr string) (net.PacketConn, error) {
+			return nil, fmt.Errorf("UDP trackers cannot be proxied")
+		}
+	}
+	return listenTCP
+}
+
+// listenPeers accepts incoming TCP peer connections in place of the
+// builtin socket, which is disabled while dialing through a proxy
+func listenPeers(config *torrent.ClientConfig) (net.Listener, error) {
+	network := "tcp"
+	if config.DisableIPv6 {
+		network = "tcp4"
+	}
+	l, err := net.Listen(network, net.JoinHostPort(config.ListenHost(network), strconv.Itoa(config.ListenPort)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen for peers: %s", err)
+	}
+	return l, nil
+}
+
+// proxyDialer returns a peer dialer which connects through the proxy
+func proxyDialer(u *url.URL) (torrent.Dialer, error) {
+	if u.Scheme == "http" || u.Scheme == "https" {
+		return torrent.NetworkDialer{Network: "tcp", Dialer: httpConnectDialer{u}}, nil
+	}
+	d, err := proxy.FromURL(u, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	cd, ok := d.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("Proxy does not support dialing with a context")
+	}
+	return torrent.NetworkDialer{Network: "tcp", Dialer: cd}, nil
+}
+
+// httpConnectDialer tunnels connections using HTTP CONNECT
+type httpConnectDialer struct {
+	proxy *url.URL
+}
+
+func (d httpConnectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, "tcp", d.proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+	if d.proxy.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: d.proxy.Hostname()})
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if user := d.proxy.User; user != nil {
+		pass, _ := user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("Proxy refused connection: %s", resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn returns data read past the CONNECT response first
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,36 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/anacrolix/torrent"
+)
+
+func TestApplyProxy(t *testing.T) {
+	u, err := ParseProxyURL("socks5://127.0.0.1:1080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		disableTCP, only  bool
+		listenTCP, accept bool
+	}{
+		{false, false, true, true},
+		{true, false, false, true},
+		{false, true, false, false},
+		{true, true, false, false},
+	} {
+		config := torrent.NewDefaultClientConfig()
+		config.DisableTCP = c.disableTCP
+		listenTCP := applyProxy(config, u, c.only)
+		if listenTCP != c.listenTCP {
+			t.Errorf("DisableTCP %v, only %v: listen TCP %v, want %v", c.disableTCP, c.only, listenTCP, c.listenTCP)
+		}
+		if !config.DisableTCP {
+			t.Errorf("DisableTCP %v, only %v: builtin TCP socket would dial directly", c.disableTCP, c.only)
+		}
+		if config.AcceptPeerConnections != c.accept {
+			t.Errorf("DisableTCP %v, only %v: accept %v, want %v", c.disableTCP, c.only, config.AcceptPeerConnections, c.accept)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"github.com/anacrolix/torrent"
+)
+
+// bytes ahead of the first missing piece which are prioritised
+const sequentialWindow = 16 << 20
+
+// updateSequential raises the priority of the first missing pieces
+// of the selected files, so pieces complete in order. It runs on
+// every update, moving the window along as pieces complete.
+func (e *Engine) updateSequential(t *Torrent) {
+	if !t.Sequential || !t.Started || !t.Loaded || t.t == nil {
+		return
+	}
+	info := t.t.Info()
+	size := int(sequentialWindow / info.PieceLength)
+	if size < 2 {
+		size = 2
+	}
+	window := map[int]bool{}
+	for _, f := range t.Files {
+		if f == nil || f.f == nil || !f.Priority {
+			continue
+		}
+		for i := f.f.BeginPieceIndex(); i < f.f.EndPieceIndex() && len(window) < size; i++ {
+			if !t.t.PieceState(i).Complete {
+				window[i] = true
+			}
+		}
+	}
+	for i := range t.window {
+		if !window[i] {
+			t.t.Piece(i).SetPriority(torrent.PiecePriorityNone)
+		}
+	}
+	for i := range window {
+		if !t.window[i] {
+			t.t.Piece(i).SetPriority(torrent.PiecePriorityHigh)
+		}
+	}
+	t.window = window
+}
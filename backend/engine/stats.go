@@ -0,0 +1,32 @@
+package engine
+
+// Stats are engine wide counters, reported with the system stats
+type Stats struct {
+	BlocklistRanges  int
+	BlocklistBlocked int64
+	DHTServers       int
+	DHTNodes         int
+	DHTGoodNodes     int
+	DHTBadNodes      int
+	PortMapStatus    string
+	PortMapProtocol  string
+	ExternalAddress  string
+	PortMapError     string
+	DiskFull         bool
+}
+
+func (e *Engine) Stats() Stats {
+	s := Stats{
+		BlocklistRanges:  e.blocklist.NumRanges(),
+		BlocklistBlocked: e.blocklist.Blocked(),
+	}
+	e.portmap.stats(&s)
+	e.mut.Lock()
+	client := e.client
+	s.DiskFull = e.diskFull
+	e.mut.Unlock()
+	if client != nil {
+		dhtStats(client, &s)
+	}
+	return s
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Storage backends for torrent data
+const (
+	StorageFile   = "file"   //files, default completion store (sqlite with cgo, otherwise bolt)
+	StorageMMap   = "mmap"   //memory mapped files
+	StorageBolt   = "bolt"   //files, bolt piece completion
+	StorageSqlite = "sqlite" //files, sqlite piece completion (requires cgo)
+)
+
+func validStorage(name string) error {
+	switch name {
+	case "", StorageFile, StorageMMap, StorageBolt:
+		return nil
+	case StorageSqlite:
+		if !sqliteAvailable {
+			return fmt.Errorf("Storage (sqlite) is not available in this build")
+		}
+		return nil
+	}
+	return fmt.Errorf("Invalid storage (%s)", name)
+}
+
+// openStorage opens the configured backend. Piece completion is
+// persisted in the download directory, so restarts don't re-hash.
+// File backends place each torrent in the directory from dirMaker
+// and optionally name incomplete files with PartExtension.
+func openStorage(c Config, dirMaker storage.TorrentDirFilePathMaker) (storage.ClientImplCloser, error) {
+	dir := c.DownloadDirectory
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if c.IncompleteDirectory != "" {
+		if err := os.MkdirAll(c.IncompleteDirectory, 0755); err != nil {
+			return nil, err
+		}
+	}
+	var pc storage.PieceCompletion
+	var err error
+	switch c.Storage {
+	case "", StorageFile:
+		pc, err = storage.NewDefaultPieceCompletionForDir(dir)
+	case StorageMMap:
+		return storage.NewMMap(dir), nil
+	case StorageBolt:
+		pc, err = storage.NewBoltPieceCompletion(dir)
+	case StorageSqlite:
+		pc, err = newSqlitePieceCompletion(dir)
+	default:
+		return nil, fmt.Errorf("Invalid storage (%s)", c.Storage)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open piece completion: %s", err)
+	}
+	if c.PartExtension {
+		return newPartStorage(dir, dirMaker, pc), nil
+	}
+	return storage.NewFileOpts(storage.NewFileClientOpts{
+		ClientBaseDir:   dir,
+		TorrentDirMaker: dirMaker,
+		PieceCompletion: pc,
+	}), nil
+}
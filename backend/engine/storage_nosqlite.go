@@ -0,0 +1,15 @@
+//go:build !cgo || nosqlite
+
+package engine
+
+import (
+	"fmt"
+
+	"github.com/anacrolix/torrent/storage"
+)
+
+const sqliteAvailable = false
+
+func newSqlitePieceCompletion(dir string) (storage.PieceCompletion, error) {
+	return nil, fmt.Errorf("sqlite requires cgo")
+}
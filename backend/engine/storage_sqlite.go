@@ -0,0 +1,11 @@
+//go:build cgo && !nosqlite
+
+package engine
+
+import "github.com/anacrolix/torrent/storage"
+
+const sqliteAvailable = true
+
+func newSqlitePieceCompletion(dir string) (storage.PieceCompletion, error) {
+	return storage.NewSqlitePieceCompletion(dir)
+}
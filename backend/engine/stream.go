@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+const defaultStreamCacheMB = 256
+
+var errPieceEvicted = errors.New("piece evicted from memory")
+
+// memoryStorage keeps the pieces of stream torrents in memory, shared
+// by all stream torrents and capped in size. The least recently used
+// pieces are evicted, reading them again makes the client re-download.
+type memoryStorage struct {
+	mut      sync.Mutex
+	capacity int64
+	used     int64
+	pieces   map[memoryKey]*memoryPiece
+	lru      list.List
+	capFunc  func() (int64, bool)
+}
+
+type memoryKey struct {
+	ih    metainfo.Hash
+	index int
+}
+
+type memoryPiece struct {
+	key      memoryKey
+	length   int64
+	data     []byte
+	complete bool
+	elem     *list.Element
+}
+
+func newMemoryStorage(capacity int64) *memoryStorage {
+	m := &memoryStorage{capacity: capacity, pieces: map[memoryKey]*memoryPiece{}}
+	m.capFunc = func() (int64, bool) { return m.capacity, true }
+	return m
+}
+
+func (m *memoryStorage) OpenTorrent(_ context.Context, info *metainfo.Info, ih metainfo.Hash) (storage.TorrentImpl, error) {
+	if info.PieceLength > m.capacity {
+		return storage.TorrentImpl{}, fmt.Errorf("Piece length exceeds the stream cache size")
+	}
+	return storage.TorrentImpl{
+		Piece: func(p metainfo.Piece) storage.PieceImpl {
+			return memoryPieceImpl{m: m, key: memoryKey{ih, p.Index()}, length: p.Length()}
+		},
+		Close: func() error {
+			m.drop(ih)
+			return nil
+		},
+		//all stream torrents share the same space
+		Capacity: &m.capFunc,
+	}, nil
+}
+
+// piece returns a stored piece, allocating it when create is set
+func (m *memoryStorage) piece(key memoryKey, length int64, create bool) *memoryPiece {
+	p, ok := m.pieces[key]
+	if ok {
+		m.lru.MoveToFront(p.elem)
+		return p
+	}
+	if !create {
+		return nil
+	}
+	p = &memoryPiece{key: key, length: length, data: make([]byte, length)}
+	p.elem = m.lru.PushFront(p)
+	m.pieces[key] = p
+	m.used += length
+	for m.used > m.capacity {
+		oldest := m.lru.Back().Value.(*memoryPiece)
+		if oldest == p {
+			break
+		}
+		m.remove(oldest)
+	}
+	return p
+}
+
+func (m *memoryStorage) remove(p *memoryPiece) {
+	m.lru.Remove(p.elem)
+	delete(m.pieces, p.key)
+	m.used -= p.length
+}
+
+func (m *memoryStorage) drop(ih metainfo.Hash) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	for key, p := range m.pieces {
+		if key.ih == ih {
+			m.remove(p)
+		}
+	}
+}
+
+type memoryPieceImpl struct {
+	m      *memoryStorage
+	key    memoryKey
+	length int64
+}
+
+func (mp memoryPieceImpl) ReadAt(b []byte, off int64) (int, error) {
+	mp.m.mut.Lock()
+	defer mp.m.mut.Unlock()
+	p := mp.m.piece(mp.key, mp.length, false)
+	if p == nil {
+		return 0, errPieceEvicted
+	}
+	if off >= p.length {
+		return 0, fmt.Errorf("Read offset beyond piece")
+	}
+	n := copy(b, p.data[off:])
+	if n < len(b) {
+		return n, fmt.Errorf("Short read")
+	}
+	return n, nil
+}
+
+func (mp memoryPieceImpl) WriteAt(b []byte, off int64) (int, error) {
+	mp.m.mut.Lock()
+	defer mp.m.mut.Unlock()
+	p := mp.m.piece(mp.key, mp.length, true)
+	if off >= p.length {
+		return 0, fmt.Errorf("Write offset beyond piece")
+	}
+	n := copy(p.data[off:], b)
+	if n < len(b) {
+		return n, fmt.Errorf("Short write")
+	}
+	return n, nil
+}
+
+func (mp memoryPieceImpl) MarkComplete() error {
+	mp.m.mut.Lock()
+	defer mp.m.mut.Unlock()
+	p := mp.m.piece(mp.key, mp.length, false)
+	if p == nil {
+		return errPieceEvicted
+	}
+	p.complete = true
+	return nil
+}
+
+func (mp memoryPieceImpl) MarkNotComplete() error {
+	mp.m.mut.Lock()
+	defer mp.m.mut.Unlock()
+	if p := mp.m.piece(mp.key, mp.length, false); p != nil {
+		p.complete = false
+	}
+	return nil
+}
+
+func (mp memoryPieceImpl) Completion() storage.Completion {
+	mp.m.mut.Lock()
+	defer mp.m.mut.Unlock()
+	p, ok := mp.m.pieces[mp.key]
+	return storage.Completion{Complete: ok && p.complete, Ok: true}
+}
+
+// NewStream adds a torrent in stream only mode, its data is kept in
+// the memory cache and only downloaded as it is read
+func (e *Engine) NewStream(spec *torrent.TorrentSpec) error {
+	return e.Add(spec, AddOptions{Stream: true})
+}
+
+// NewStreamMagnet adds a magnet in stream only mode
+func (e *Engine) NewStreamMagnet(magnetURI string) error {
+	return e.AddMagnet(magnetURI, AddOptions{Stream: true})
+}
+
+// OpenFile returns a reader for a file of a torrent, prioritising
+// the pieces as they are read
+func (e *Engine) OpenFile(infohash, path string) (torrent.Reader, *File, error) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t, err := e.getTorrent(infohash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !t.Loaded {
+		return nil, nil, conflict("Torrent metadata not loaded yet")
+	}
+	for _, f := range t.Files {
+		if f != nil && f.f != nil && f.Path == path {
+			return f.f.NewReader(), f, nil
+		}
+	}
+	return nil, nil, notFound("Missing file %s", path)
+}
@@ -12,18 +12,42 @@ type Torrent struct {
 	Name       string
 	Loaded     bool
 	Downloaded int64
+	Uploaded   int64
 	Size       int64
 	Files      []*File
 	//cloud torrent
 	Started      bool
 	Dropped      bool
+	AddedAt      time.Time
 	Percent      float32
+	Ratio        float32 //uploaded per downloaded byte
 	DownloadRate float32
 	UploadRate   float32
 	Peers        int
-	t            *torrent.Torrent
-	updatedAt    time.Time
-	uploaded     int64
+	Stream       bool   //data kept in memory, downloaded as it is read
+	Error        string //why the torrent is paused, e.g. "Disk full"
+	//add options
+	SavePath      string
+	Category      string
+	Tags          []string
+	Sequential    bool
+	DownloadLimit int
+	UploadLimit   int
+	//archive extraction
+	ExtractStatus   string
+	ExtractPercent  float32
+	ExtractError    string
+	t               *torrent.Torrent
+	options         *AddOptions
+	storage         *limitStorage //wraps the storage of the torrent
+	window          map[int]bool  //sequential pieces, see updateSequential
+	diskPaused      bool
+	moving          bool
+	moveFailed      bool //not retried until started again
+	extractChecked  bool
+	archives        []*archive
+	archivesDeleted bool
+	updatedAt       time.Time
 }
 
 type File struct {
@@ -48,6 +72,23 @@ func (torrent *Torrent) Update(t *torrent.Torrent) {
 	torrent.t = t
 }
 
+// snapshot copies the torrent and its files, so it can be read
+// without holding the engine lock
+func (torrent *Torrent) snapshot() *Torrent {
+	c := *torrent
+	c.Tags = append([]string(nil), torrent.Tags...)
+	if torrent.Files != nil {
+		c.Files = make([]*File, len(torrent.Files))
+		for i, f := range torrent.Files {
+			if f != nil {
+				file := *f
+				c.Files[i] = &file
+			}
+		}
+	}
+	return &c
+}
+
 func (torrent *Torrent) updateLoaded(t *torrent.Torrent) {
 
 	torrent.Size = t.Length()
@@ -67,7 +108,7 @@ func (torrent *Torrent) updateLoaded(t *torrent.Torrent) {
 		if file == nil {
 			file = &File{
 				Path:     path,
-				Priority: true, // Default: all files are selected for download
+				Priority: torrent.options.wants(i, path), // Default: all files, unless selected when added
 			}
 			torrent.Files[i] = file
 		}
@@ -128,8 +169,7 @@ func (torrent *Torrent) updateLoaded(t *torrent.Torrent) {
 	}
 
 	stats := t.Stats()
-	// Upload tracking - BytesWrittenData is not directly accessible, use 0 for now
-	uploaded := int64(0)
+	uploaded := stats.BytesWrittenData.Int64()
 
 	// Get peer count
 	torrent.Peers = stats.ActivePeers
@@ -145,14 +185,17 @@ func (torrent *Torrent) updateLoaded(t *torrent.Torrent) {
 		}
 
 		// Calculate upload rate
-		du := float32(uploaded - torrent.uploaded)
+		du := float32(uploaded - torrent.Uploaded)
 		uploadRate := du * (float32(time.Second) / dt)
 		if uploadRate >= 0 {
 			torrent.UploadRate = uploadRate
 		}
 	}
 	torrent.Downloaded = bytesCompleted
-	torrent.uploaded = uploaded
+	torrent.Uploaded = uploaded
+	if bytesCompleted > 0 {
+		torrent.Ratio = float32(uploaded) / float32(bytesCompleted)
+	}
 	torrent.updatedAt = now
 }
 
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const watchInterval = 3 * time.Second
+
+// watch polls dir for .torrent and .magnet files until done is closed
+func (e *Engine) watch(dir string, done chan struct{}) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Watch directory unavailable: %s", err)
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(watchInterval):
+		}
+		if err := e.scanWatchDirectory(dir); err != nil {
+			log.Printf("Watch directory scan failed: %s", err)
+		}
+	}
+}
+
+func (e *Engine) scanWatchDirectory(dir string) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		ext := strings.ToLower(filepath.Ext(info.Name()))
+		if info.IsDir() || (ext != ".torrent" && ext != ".magnet") {
+			continue
+		}
+		//skip files which may still be being written
+		if time.Since(info.ModTime()) < watchInterval {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		if err := e.importFile(path, ext); err != nil {
+			log.Printf("Watch directory: %s: %s", info.Name(), err)
+			os.Rename(path, path+".invalid")
+			ioutil.WriteFile(path+".invalid.txt", []byte(err.Error()+"\n"), 0644)
+			continue
+		}
+		os.Rename(path, path+".added")
+	}
+	return nil
+}
+
+func (e *Engine) importFile(path, ext string) error {
+	if ext == ".magnet" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return e.NewMagnet(strings.TrimSpace(string(b)))
+	}
+	mi, err := metainfo.LoadFromFile(path)
+	if err != nil {
+		return fmt.Errorf("Invalid torrent file: %s", err)
+	}
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		return fmt.Errorf("Invalid torrent file: %s", err)
+	}
+	return e.NewTorrent(spec)
+}
@@ -14,6 +14,7 @@ func main() {
 		Title:      "Remote Torrent",
 		Port:       3000,
 		ConfigPath: "remote-torrent.json",
+		FeedsPath:  "remote-torrent-feeds.json",
 	}
 
 	o := opts.New(&s)
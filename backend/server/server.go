@@ -9,10 +9,12 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/NYTimes/gziphandler"
@@ -33,14 +35,18 @@ type Server struct {
 	Host       string `help:"Listening interface (default all)"`
 	Auth       string `help:"Optional basic auth in form 'user:password'" env:"AUTH"`
 	ConfigPath string `help:"Configuration file path"`
+	FeedsPath  string `help:"RSS feed subscriptions file path"`
 	KeyPath    string `help:"TLS Key file path"`
 	CertPath   string `help:"TLS Certicate file path" short:"r"`
 	Log        bool   `help:"Enable request logging"`
 	Open       bool   `help:"Open now with your default browser"`
 	//http handlers
 	files, static http.Handler
+	rest          http.Handler
 	scraper       *scraper.Handler
 	scraperh      http.Handler
+	//rss subscriptions
+	feeds feedStore
 	//torrent engine
 	engine *engine.Engine
 	state  struct {
@@ -77,6 +83,7 @@ func (s *Server) Run(version string) error {
 	//will use a the local embed/ dir if it exists, otherwise will use the hardcoded embedded binaries
 	s.files = http.HandlerFunc(s.serveFiles)
 	s.static = ctstatic.FileSystemHandler()
+	s.rest = s.restRouter()
 	s.scraper = &scraper.Handler{
 		Log: false, Debug: false,
 		Headers: map[string]string{
@@ -89,7 +96,6 @@ func (s *Server) Run(version string) error {
 	}
 	//scraper
 	s.state.SearchProviders = s.scraper.Config //share scraper config
-	go s.fetchSearchConfigLoop()
 	s.scraperh = http.StripPrefix("/search", s.scraper)
 	//torrent engine
 	s.engine = engine.New()
@@ -114,6 +120,23 @@ func (s *Server) Run(version string) error {
 	if err := s.reconfigure(c); err != nil {
 		return fmt.Errorf("initial configure failed: %s", err)
 	}
+	//remove port mappings on shutdown
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		s.engine.Close()
+		os.Exit(0)
+	}()
+	//fetch search providers (through the configured proxy)
+	go s.fetchSearchConfigLoop()
+	//load and poll rss feeds
+	s.feeds.path = s.FeedsPath
+	s.feeds.add = s.addFeedItem
+	if err := s.feeds.load(); err != nil {
+		return err
+	}
+	go s.feedsLoop()
 	//poll torrents and files
 	go func() {
 		for {
@@ -129,7 +152,7 @@ func (s *Server) Run(version string) error {
 	go func() {
 		for {
 			c := s.engine.Config()
-			s.state.Stats.System.loadStats(c.DownloadDirectory)
+			s.state.Stats.System.loadStats(c.DownloadDirectory, s.engine.Stats())
 			time.Sleep(5 * time.Second)
 		}
 	}()
@@ -196,6 +219,27 @@ func (s *Server) reconfigure(c engine.Config) error {
 		return fmt.Errorf("Invalid path")
 	}
 	c.DownloadDirectory = dldir
+	if c.WatchDirectory != "" {
+		watchdir, err := filepath.Abs(c.WatchDirectory)
+		if err != nil {
+			return fmt.Errorf("Invalid watch path")
+		}
+		c.WatchDirectory = watchdir
+	}
+	if c.IncompleteDirectory != "" {
+		incdir, err := filepath.Abs(c.IncompleteDirectory)
+		if err != nil {
+			return fmt.Errorf("Invalid incomplete path")
+		}
+		c.IncompleteDirectory = incdir
+	}
+	if c.Blocklist != "" {
+		blocklist, err := filepath.Abs(c.Blocklist)
+		if err != nil {
+			return fmt.Errorf("Invalid blocklist path")
+		}
+		c.Blocklist = blocklist
+	}
 	if err := s.engine.Configure(c); err != nil {
 		return err
 	}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/metainfo"
@@ -29,31 +30,14 @@ func (s *Server) api(r *http.Request) error {
 
 	//convert url into torrent bytes
 	if action == "url" {
-		url := string(data)
-		remote, err := http.Get(url)
-		if err != nil {
-			return fmt.Errorf("Invalid remote torrent URL: %s (%s)", err, url)
-		}
-		//TODO enforce max body size (32k?)
-		data, err = ioutil.ReadAll(remote.Body)
-		if err != nil {
-			return fmt.Errorf("Failed to download remote torrent: %s", err)
-		}
-		action = "torrentfile"
+		_, err := s.addTorrentURL(string(data), engine.AddOptions{})
+		return err
 	}
 
 	//convert torrent bytes into magnet
 	if action == "torrentfile" {
-		reader := bytes.NewBuffer(data)
-		info, err := metainfo.Load(reader)
-		if err != nil {
-			return err
-		}
-		spec := torrent.TorrentSpecFromMetaInfo(info)
-		if err := s.engine.NewTorrent(spec); err != nil {
-			return fmt.Errorf("Torrent error: %s", err)
-		}
-		return nil
+		_, err := s.addTorrentFile(data, engine.AddOptions{})
+		return err
 	}
 
 	//update after action completes
@@ -93,6 +77,10 @@ func (s *Server) api(r *http.Request) error {
 			if err := s.engine.DeleteTorrent(infohash); err != nil {
 				return err
 			}
+		} else if state == "deletedata" {
+			if _, err := s.engine.DeleteTorrentData(infohash); err != nil {
+				return err
+			}
 		} else {
 			return fmt.Errorf("Invalid state: %s", state)
 		}
@@ -120,3 +108,55 @@ func (s *Server) api(r *http.Request) error {
 	}
 	return nil
 }
+
+// addTorrentURL downloads a remote .torrent file, or the magnet it
+// redirects to, and adds it to the engine, returning its infohash
+func (s *Server) addTorrentURL(url string, opts engine.AddOptions) (string, error) {
+	data, magnet, err := s.fetchTorrentURL(url)
+	if err != nil {
+		return "", err
+	}
+	if magnet != "" {
+		m, err := metainfo.ParseMagnetUri(magnet)
+		if err != nil {
+			return "", fmt.Errorf("Magnet error: %s", err)
+		}
+		if err := s.engine.AddMagnet(magnet, opts); err != nil {
+			return "", fmt.Errorf("Magnet error: %w", err)
+		}
+		return m.InfoHash.HexString(), nil
+	}
+	return s.addTorrentFile(data, opts)
+}
+
+// addTorrentFile adds the given .torrent file contents to the engine,
+// returning its infohash
+func (s *Server) addTorrentFile(data []byte, opts engine.AddOptions) (string, error) {
+	reader := bytes.NewBuffer(data)
+	info, err := metainfo.Load(reader)
+	if err != nil {
+		return "", fmt.Errorf("Invalid torrent file: %s", err)
+	}
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(info)
+	if err != nil {
+		return "", fmt.Errorf("Invalid torrent file: %s", err)
+	}
+	if err := s.engine.Add(spec, opts); err != nil {
+		return "", fmt.Errorf("Torrent error: %w", err)
+	}
+	return spec.InfoHash.HexString(), nil
+}
+
+// httpClient returns a client for outbound fetches which uses the
+// configured proxy. A zero timeout means no timeout.
+func (s *Server) httpClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	//clients are short lived, don't leave idle connections behind
+	transport.DisableKeepAlives = true
+	if p := s.engine.Config().ProxyURL; p != "" {
+		if u, err := engine.ParseProxyURL(p); err == nil {
+			transport.Proxy = http.ProxyURL(u)
+		}
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jpillora/cloud-torrent/engine"
+)
+
+// bulkResult is the outcome of a bulk action on one torrent
+type bulkResult struct {
+	InfoHash string   `json:"infoHash"`
+	Error    string   `json:"error,omitempty"`
+	Kept     []string `json:"kept,omitempty"` // shared files not deleted by removeData
+}
+
+// bulkRequest applies an action to the listed torrents, or to the
+// torrents matching the filter. Actions are start, stop, remove,
+// removeData, recheck, setCategory and setLimits.
+type bulkRequest struct {
+	InfoHashes    []string    `json:"infoHashes"`
+	Filter        *bulkFilter `json:"filter"`
+	Action        string      `json:"action"`
+	Category      string      `json:"category"`      // for setCategory
+	DownloadLimit int         `json:"downloadLimit"` // for setLimits
+	UploadLimit   int         `json:"uploadLimit"`   // for setLimits
+}
+
+type bulkFilter struct {
+	Tag      string `json:"tag"`
+	Category string `json:"category"`
+	State    string `json:"state"` // started, stopped, complete or error
+	Name     string `json:"name"`  // part of the name, ignoring case
+}
+
+type bulkResponse struct {
+	Results []bulkResult `json:"results"`
+}
+
+// bulkTorrents applies an action to a list of torrents, or to the
+// torrents matching a filter
+func (s *Server) bulkTorrents(w http.ResponseWriter, r *http.Request) {
+	var req bulkRequest
+
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	var apply func(infohash string) (kept []string, err error)
+	switch req.Action {
+	case "start":
+		apply = noKept(s.engine.StartTorrent)
+	case "stop":
+		apply = noKept(s.engine.StopTorrent)
+	case "remove":
+		apply = noKept(s.engine.DeleteTorrent)
+	case "removeData":
+		apply = s.engine.DeleteTorrentData
+	case "recheck":
+		apply = noKept(s.engine.RecheckTorrent)
+	case "setCategory":
+		apply = noKept(func(infohash string) error {
+			return s.engine.SetCategory(infohash, req.Category)
+		})
+	case "setLimits":
+		apply = noKept(func(infohash string) error {
+			return s.engine.SetLimits(infohash, req.DownloadLimit, req.UploadLimit)
+		})
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid action: %s", req.Action))
+		return
+	}
+
+	infohashes := req.InfoHashes
+	if req.Filter != nil {
+		if len(infohashes) > 0 {
+			writeError(w, http.StatusBadRequest, "Either infoHashes or filter is required, not both")
+			return
+		}
+		//an empty filter would match every torrent
+		if *req.Filter == (bulkFilter{}) {
+			writeErrorDetails(w, http.StatusBadRequest, "Invalid filter", map[string]string{
+				"filter": "must set tag, category, state or name",
+			})
+			return
+		}
+		torrents, err := s.engine.FindTorrents(engine.TorrentFilter{
+			Tag:      req.Filter.Tag,
+			Category: req.Filter.Category,
+			State:    req.Filter.State,
+			Name:     req.Filter.Name,
+		})
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid filter: %s", err))
+			return
+		}
+		infohashes = []string{}
+		for _, t := range torrents {
+			infohashes = append(infohashes, t.InfoHash)
+		}
+	} else if len(infohashes) == 0 {
+		writeError(w, http.StatusBadRequest, "InfoHashes or filter is required")
+		return
+	} else {
+		invalid := []string{}
+		for i, infohash := range infohashes {
+			if !validInfohash(infohash) {
+				invalid = append(invalid, infohash)
+			}
+			infohashes[i] = strings.ToLower(infohash)
+		}
+		if len(invalid) > 0 {
+			writeErrorDetails(w, http.StatusBadRequest, "Invalid infohashes", map[string][]string{"infoHashes": invalid})
+			return
+		}
+	}
+
+	results := []bulkResult{}
+	for _, infohash := range infohashes {
+		result := bulkResult{InfoHash: infohash}
+		kept, err := apply(infohash)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.Kept = kept
+		results = append(results, result)
+	}
+
+	s.state.Push()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bulkResponse{Results: results})
+}
+
+func noKept(fn func(string) error) func(string) ([]string, error) {
+	return func(infohash string) ([]string, error) {
+		return nil, fn(infohash)
+	}
+}
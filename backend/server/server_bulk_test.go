@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jpillora/cloud-torrent/engine"
+)
+
+func TestBulkTorrentsInvalid(t *testing.T) {
+	s := &Server{engine: engine.New()}
+	for _, body := range []string{
+		`{"action":"stop","filter":{}}`,
+		`{"action":"stop"}`,
+		`{"action":"stop","infoHashes":["nope"]}`,
+		`{"action":"stop","infoHashes":["` + strings.Repeat("ab", 20) + `"],"filter":{"tag":"a"}}`,
+		`{"action":"explode","filter":{"tag":"a"}}`,
+	} {
+		w := httptest.NewRecorder()
+		s.bulkTorrents(w, httptest.NewRequest("POST", "/api/v1/torrents/bulk", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, w.Code)
+		}
+	}
+}
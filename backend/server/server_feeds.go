@@ -0,0 +1,530 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpillora/cloud-torrent/engine"
+)
+
+const (
+	defaultFeedInterval = 15 //minutes
+	feedSeenLimit       = 1000
+	feedMaxBodySize     = 10 << 20
+)
+
+var errFeedNotFound = errors.New("Feed not found")
+var errFeedBusy = errors.New("Feed is already being checked")
+
+// feed is an RSS/Atom subscription with auto-download rules
+type feed struct {
+	ID string `json:"id"`
+	feedSettings
+	//status
+	LastChecked time.Time `json:"lastChecked"`
+	LastError   string    `json:"lastError"`
+	Seen        []string  `json:"seen"`     //ids of items already processed
+	Episodes    []string  `json:"episodes"` //episode keys already downloaded
+	checking    bool
+}
+
+// feedSettings are the parts of a feed set by users
+type feedSettings struct {
+	Name     string     `json:"name"`
+	URL      string     `json:"url"`
+	Interval int        `json:"interval"` //minutes between polls
+	Enabled  bool       `json:"enabled"`
+	Rules    []feedRule `json:"rules"`
+	Dedupe   bool       `json:"dedupe"` //skip episodes which were already downloaded
+}
+
+// feedRule matches an item title when Include matches (or is empty)
+// and Exclude does not match (or is empty)
+type feedRule struct {
+	Include string `json:"include"`
+	Exclude string `json:"exclude"`
+}
+
+type feedItem struct {
+	ID    string
+	Title string
+	Link  string
+}
+
+type feedStore struct {
+	sync.Mutex
+	path  string
+	feeds []*feed
+	//adds the link of an item, see addFeedItem
+	add func(link string) error
+}
+
+func (fs *feedStore) load() error {
+	b, err := ioutil.ReadFile(fs.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Read feeds error: %s", err)
+	} else if len(b) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(b, &fs.feeds); err != nil {
+		return fmt.Errorf("Malformed feeds: %s", err)
+	}
+	return nil
+}
+
+// save writes all feeds to disk, the store must be locked
+func (fs *feedStore) save() {
+	b, _ := json.MarshalIndent(fs.feeds, "", "  ")
+	if err := ioutil.WriteFile(fs.path, b, 0644); err != nil {
+		log.Printf("Failed to save feeds: %s", err)
+	}
+}
+
+func (fs *feedStore) get(id string) *feed {
+	for _, f := range fs.feeds {
+		if f.ID == id {
+			return f
+		}
+	}
+	return nil
+}
+
+// feedsLoop polls each enabled feed once its interval has elapsed
+func (s *Server) feedsLoop() {
+	for {
+		var due []string
+		s.feeds.Lock()
+		for _, f := range s.feeds.feeds {
+			interval := time.Duration(f.Interval) * time.Minute
+			if f.Enabled && time.Since(f.LastChecked) >= interval {
+				due = append(due, f.ID)
+			}
+		}
+		s.feeds.Unlock()
+		for _, id := range due {
+			if _, err := s.checkFeed(id); err != nil {
+				log.Printf("Feed %s failed: %s", id, err)
+			}
+		}
+		time.Sleep(1 * time.Minute)
+	}
+}
+
+// checkFeed fetches a feed and adds every new matching item,
+// returning the titles of the added items. The store is unlocked while
+// fetching and adding, which may take a while per item.
+func (s *Server) checkFeed(id string) ([]string, error) {
+	s.feeds.Lock()
+	f := s.feeds.get(id)
+	if f == nil {
+		s.feeds.Unlock()
+		return nil, errFeedNotFound
+	}
+	if f.checking {
+		s.feeds.Unlock()
+		return nil, errFeedBusy
+	}
+	f.checking = true
+	url := f.URL
+	s.feeds.Unlock()
+	defer func() {
+		s.feeds.Lock()
+		f.checking = false
+		s.feeds.Unlock()
+	}()
+
+	items, err := fetchFeed(s.httpClient(30*time.Second), url)
+
+	//pick the new matching items
+	s.feeds.Lock()
+	//feed may have been removed during the fetch
+	if s.feeds.get(id) == nil {
+		s.feeds.Unlock()
+		return nil, errFeedNotFound
+	}
+	f.LastChecked = time.Now()
+	if err != nil {
+		f.LastError = err.Error()
+		s.feeds.save()
+		s.feeds.Unlock()
+		return nil, err
+	}
+	f.LastError = ""
+	name, dedupe := f.Name, f.Dedupe
+	candidates := []feedItem{}
+	for _, item := range items {
+		if contains(f.Seen, item.ID) {
+			continue
+		}
+		if !f.matches(item.Title) {
+			f.markSeen(item.ID)
+			continue
+		}
+		if ep := episodeKey(item.Title); dedupe && ep != "" && contains(f.Episodes, ep) {
+			f.markSeen(item.ID)
+			continue
+		}
+		candidates = append(candidates, item)
+	}
+	s.feeds.save()
+	s.feeds.Unlock()
+
+	//add them unlocked
+	added := []string{}
+	seen := []string{}
+	episodes := []string{}
+	for _, item := range candidates {
+		ep := episodeKey(item.Title)
+		if dedupe && ep != "" && contains(episodes, ep) {
+			seen = append(seen, item.ID)
+			continue
+		}
+		if err := s.feeds.add(item.Link); err != nil {
+			//leave unseen, retry on the next poll
+			log.Printf("Feed %s: %s: %s", name, item.Title, err)
+			continue
+		}
+		seen = append(seen, item.ID)
+		if ep != "" {
+			episodes = append(episodes, ep)
+		}
+		added = append(added, item.Title)
+	}
+
+	//and record them
+	s.feeds.Lock()
+	if s.feeds.get(id) != nil {
+		for _, id := range seen {
+			f.markSeen(id)
+		}
+		f.Episodes = append(f.Episodes, episodes...)
+		s.feeds.save()
+	}
+	s.feeds.Unlock()
+	if len(added) > 0 {
+		log.Printf("Feed %s: added %d torrents", name, len(added))
+		s.state.Push()
+	}
+	return added, nil
+}
+
+// addFeedItem adds a magnet or remote .torrent using the same path as
+// the legacy "url" action. Torrents which are already added count as
+// added, so they are not retried.
+func (s *Server) addFeedItem(link string) error {
+	if link == "" {
+		return errors.New("Missing torrent link")
+	}
+	var err error
+	if strings.HasPrefix(link, "magnet:") {
+		err = s.engine.NewMagnet(link)
+	} else {
+		_, err = s.addTorrentURL(link, engine.AddOptions{})
+	}
+	if errors.Is(err, engine.ErrConflict) {
+		return nil
+	}
+	return err
+}
+
+func (f *feed) matches(title string) bool {
+	if len(f.Rules) == 0 {
+		return true
+	}
+	for _, r := range f.Rules {
+		if r.Include != "" && !matchRule(r.Include, title) {
+			continue
+		}
+		if r.Exclude != "" && matchRule(r.Exclude, title) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (f *feed) markSeen(id string) {
+	f.Seen = append(f.Seen, id)
+	if len(f.Seen) > feedSeenLimit {
+		f.Seen = f.Seen[len(f.Seen)-feedSeenLimit:]
+	}
+}
+
+// validate checks the feed URL and rules and applies defaults
+func (f *feedSettings) validate() error {
+	if !strings.HasPrefix(f.URL, "http://") && !strings.HasPrefix(f.URL, "https://") {
+		return errors.New("Feed URL must be http or https")
+	}
+	for _, r := range f.Rules {
+		for _, expr := range []string{r.Include, r.Exclude} {
+			if _, err := regexp.Compile("(?i)" + expr); err != nil {
+				return fmt.Errorf("Invalid rule: %s", err)
+			}
+		}
+	}
+	if f.Interval <= 0 {
+		f.Interval = defaultFeedInterval
+	}
+	if f.Name == "" {
+		f.Name = f.URL
+	}
+	return nil
+}
+
+func matchRule(expr, title string) bool {
+	re, err := regexp.Compile("(?i)" + expr)
+	return err == nil && re.MatchString(title)
+}
+
+var episodeRe = regexp.MustCompile(`(?i)^(.+?)[\s._-]+(?:s(\d{1,2})[\s._-]?e(\d{1,3})|(\d{1,2})x(\d{2,3}))\b`)
+var nonAlnumRe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// episodeKey extracts a normalised "show s01e02" key from a release
+// title, or returns an empty string when the title has no episode
+func episodeKey(title string) string {
+	m := episodeRe.FindStringSubmatch(title)
+	if m == nil {
+		return ""
+	}
+	show := strings.TrimSpace(nonAlnumRe.ReplaceAllString(strings.ToLower(m[1]), " "))
+	season, episode := m[2], m[3]
+	if season == "" {
+		season, episode = m[4], m[5]
+	}
+	sn, _ := strconv.Atoi(season)
+	en, _ := strconv.Atoi(episode)
+	return fmt.Sprintf("%s s%02de%02d", show, sn, en)
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+//RSS 2.0, RSS 1.0 and Atom documents
+
+type feedDocument struct {
+	Channel struct {
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items   []rssItem   `xml:"item"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title     string `xml:"title"`
+	Link      string `xml:"link"`
+	GUID      string `xml:"guid"`
+	MagnetURI string `xml:"magnetURI"`
+	Enclosure struct {
+		URL  string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"enclosure"`
+}
+
+type atomEntry struct {
+	ID    string `xml:"id"`
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"link"`
+}
+
+func fetchFeed(client *http.Client, url string) ([]feedItem, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status: %s", resp.Status)
+	}
+	return parseFeed(io.LimitReader(resp.Body, feedMaxBodySize))
+}
+
+func parseFeed(r io.Reader) ([]feedItem, error) {
+	doc := feedDocument{}
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("Invalid feed: %s", err)
+	}
+	items := []feedItem{}
+	for _, i := range append(doc.Channel.Items, doc.Items...) {
+		link := i.MagnetURI
+		if link == "" && (i.Enclosure.Type == "application/x-bittorrent" || strings.HasPrefix(i.Enclosure.URL, "magnet:")) {
+			link = i.Enclosure.URL
+		}
+		if link == "" {
+			link = strings.TrimSpace(i.Link)
+		}
+		if link == "" {
+			link = i.Enclosure.URL
+		}
+		id := i.GUID
+		if id == "" {
+			id = link
+		}
+		items = append(items, feedItem{ID: id, Title: strings.TrimSpace(i.Title), Link: link})
+	}
+	for _, e := range doc.Entries {
+		link := ""
+		for _, l := range e.Links {
+			if l.Type == "application/x-bittorrent" || strings.HasPrefix(l.Href, "magnet:") {
+				link = l.Href
+				break
+			}
+			if link == "" && (l.Rel == "" || l.Rel == "alternate" || l.Rel == "enclosure") {
+				link = l.Href
+			}
+		}
+		id := e.ID
+		if id == "" {
+			id = link
+		}
+		items = append(items, feedItem{ID: id, Title: strings.TrimSpace(e.Title), Link: link})
+	}
+	return items, nil
+}
+
+func newFeedID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//REST handlers
+
+// getFeeds returns all feed subscriptions
+func (s *Server) getFeeds(w http.ResponseWriter, r *http.Request) {
+	s.feeds.Lock()
+	defer s.feeds.Unlock()
+
+	res := []feedV1{}
+	for _, f := range s.feeds.feeds {
+		res = append(res, newFeedV1(f))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// addFeed subscribes to a new feed
+func (s *Server) addFeed(w http.ResponseWriter, r *http.Request) {
+	req := feedRequestV1{Enabled: true}
+
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	settings := req.feedSettings()
+	if err := settings.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f := &feed{ID: newFeedID(), feedSettings: settings}
+
+	s.feeds.Lock()
+	s.feeds.feeds = append(s.feeds.feeds, f)
+	s.feeds.save()
+	s.feeds.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newFeedV1(f))
+}
+
+// updateFeed changes the URL, rules or schedule of a feed
+func (s *Server) updateFeed(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var req feedRequestV1
+
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	settings := req.feedSettings()
+	if err := settings.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.feeds.Lock()
+	defer s.feeds.Unlock()
+
+	f := s.feeds.get(id)
+	if f == nil {
+		writeError(w, http.StatusNotFound, errFeedNotFound.Error())
+		return
+	}
+	f.feedSettings = settings
+	s.feeds.save()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newFeedV1(f))
+}
+
+// deleteFeed unsubscribes from a feed
+func (s *Server) deleteFeed(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	s.feeds.Lock()
+	defer s.feeds.Unlock()
+
+	for i, f := range s.feeds.feeds {
+		if f.ID == id {
+			s.feeds.feeds = append(s.feeds.feeds[:i], s.feeds.feeds[i+1:]...)
+			s.feeds.save()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(success)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, errFeedNotFound.Error())
+}
+
+type refreshResponse struct {
+	Added []string `json:"added"` // titles of the added items
+}
+
+// refreshFeed polls a feed immediately and returns the added items
+func (s *Server) refreshFeed(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	added, err := s.checkFeed(id)
+	if err == errFeedNotFound {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err == errFeedBusy {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Sprintf("Failed to refresh feed: %s", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(refreshResponse{Added: added})
+}
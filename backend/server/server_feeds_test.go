@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jpillora/cloud-torrent/engine"
+)
+
+const testFeed = `<?xml version="1.0"?>
+<rss version="2.0"><channel>
+<item><title>Show.S01E01.720p</title><link>magnet:?xt=urn:btih:1</link><guid>1</guid></item>
+<item><title>Show.S01E01.1080p</title><link>magnet:?xt=urn:btih:2</link><guid>2</guid></item>
+<item><title>Show.S01E02.CAM</title><link>magnet:?xt=urn:btih:3</link><guid>3</guid></item>
+<item><title>Other.S01E01.720p</title><link>magnet:?xt=urn:btih:4</link><guid>4</guid></item>
+<item><title>Show.S01E03.720p</title><link>magnet:?xt=urn:btih:5</link><guid>5</guid></item>
+</channel></rss>`
+
+// newFeedServer returns a server subscribed to one feed served by
+// ts, recording the links it adds
+func newFeedServer(t *testing.T, ts *httptest.Server, settings feedSettings) (*Server, *[]string) {
+	s := &Server{engine: engine.New()}
+	s.feeds.path = filepath.Join(t.TempDir(), "feeds.json")
+	links := []string{}
+	s.feeds.add = func(link string) error {
+		links = append(links, link)
+		return nil
+	}
+	settings.URL = ts.URL
+	s.feeds.feeds = []*feed{{ID: "test", feedSettings: settings}}
+	return s, &links
+}
+
+func TestCheckFeed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testFeed)
+	}))
+	defer ts.Close()
+
+	s, links := newFeedServer(t, ts, feedSettings{
+		Rules:  []feedRule{{Include: `^show\.`, Exclude: `cam`}},
+		Dedupe: true,
+	})
+	added, err := s.checkFeed("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the 1080p release is the same episode, CAM and Other are ruled out
+	want := []string{"Show.S01E01.720p", "Show.S01E03.720p"}
+	if !reflect.DeepEqual(added, want) {
+		t.Fatalf("added %q, want %q", added, want)
+	}
+	if want := []string{"magnet:?xt=urn:btih:1", "magnet:?xt=urn:btih:5"}; !reflect.DeepEqual(*links, want) {
+		t.Fatalf("added links %q, want %q", *links, want)
+	}
+	f := s.feeds.get("test")
+	if want := []string{"show s01e01", "show s01e03"}; !reflect.DeepEqual(f.Episodes, want) {
+		t.Fatalf("episodes %q, want %q", f.Episodes, want)
+	}
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		if !contains(f.Seen, id) {
+			t.Errorf("item %s not seen", id)
+		}
+	}
+
+	//nothing new on the next poll
+	if added, err := s.checkFeed("test"); err != nil || len(added) > 0 {
+		t.Fatalf("second check added %q (%v)", added, err)
+	}
+}
+
+func TestCheckFeedRetry(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testFeed)
+	}))
+	defer ts.Close()
+
+	s, links := newFeedServer(t, ts, feedSettings{Rules: []feedRule{{Include: `s01e03`}}})
+	add := s.feeds.add
+	s.feeds.add = func(link string) error { return errors.New("add failed") }
+	if added, err := s.checkFeed("test"); err != nil || len(added) > 0 {
+		t.Fatalf("failing add added %q (%v)", added, err)
+	}
+	f := s.feeds.get("test")
+	if contains(f.Seen, "5") || len(f.Episodes) > 0 {
+		t.Fatalf("failed item recorded, seen %q, episodes %q", f.Seen, f.Episodes)
+	}
+
+	//failed items are retried
+	s.feeds.add = add
+	if added, err := s.checkFeed("test"); err != nil || len(added) != 1 || len(*links) != 1 {
+		t.Fatalf("retry added %q (%v)", added, err)
+	}
+}
+
+func TestCheckFeedError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	}))
+	defer ts.Close()
+
+	s, _ := newFeedServer(t, ts, feedSettings{})
+	if _, err := s.checkFeed("test"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if f := s.feeds.get("test"); f.LastError == "" || f.LastChecked.IsZero() {
+		t.Fatalf("error not recorded: %+v", f)
+	}
+	if _, err := s.checkFeed("missing"); err != errFeedNotFound {
+		t.Fatalf("missing feed returned %v", err)
+	}
+}
+
+func TestGetFeeds(t *testing.T) {
+	s := &Server{}
+	s.feeds.feeds = []*feed{{
+		ID:           "test",
+		feedSettings: feedSettings{Name: "Show", URL: "http://example.com/rss"},
+		Seen:         []string{"1"},
+		Episodes:     []string{"show s01e01"},
+	}}
+	w := httptest.NewRecorder()
+	s.getFeeds(w, httptest.NewRequest("GET", "/api/v1/feeds", nil))
+
+	res := []map[string]interface{}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 1 {
+		t.Fatalf("invalid response %s (%v)", w.Body, err)
+	}
+	for _, name := range []string{"id", "name", "url", "interval", "enabled", "rules", "dedupe", "lastChecked", "lastError"} {
+		if _, ok := res[0][name]; !ok {
+			t.Errorf("missing field %s", name)
+		}
+	}
+	for _, name := range []string{"seen", "episodes", "Name"} {
+		if _, ok := res[0][name]; ok {
+			t.Errorf("unexpected field %s", name)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/jpillora/archive"
+	"github.com/jpillora/cloud-torrent/engine"
 )
 
 const fileNumberLimit = 1000
@@ -20,6 +21,7 @@ type fsNode struct {
 	Name     string
 	Size     int64
 	Modified time.Time
+	Partial  bool //incomplete file, named with engine.PartExtension
 	Children []*fsNode
 }
 
@@ -94,6 +96,7 @@ func list(path string, info os.FileInfo, node *fsNode, n *int) error {
 	node.Size = info.Size()
 	node.Modified = info.ModTime()
 	if !info.IsDir() {
+		node.Partial = strings.HasSuffix(info.Name(), engine.PartExtension)
 		return nil
 	}
 	children, err := ioutil.ReadDir(path)
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jpillora/cloud-torrent/engine"
+)
+
+// torrent lists may be filtered, sorted, paged and trimmed to some
+// fields with these query parameters
+var listParams = []queryParam{
+	{"state", "string", "started, stopped, complete or error"},
+	{"tag", "string", "only torrents with this tag"},
+	{"category", "string", "only torrents in this category"},
+	{"name", "string", "part of the name, ignoring case"},
+	{"sort", "string", "added (default), name, progress, rate, size or ratio, prefixed with - to reverse"},
+	{"limit", "integer", fmt.Sprintf("torrents per page, at most %d", maxListLimit)},
+	{"offset", "integer", "torrents to skip"},
+	{"cursor", "string", "continue after the page which returned it"},
+	{"fields", "string", "comma separated fields to return"},
+}
+
+const maxListLimit = 1000
+
+// listKey orders torrents, ties are broken by infohash so the order
+// is stable
+type listKey struct {
+	Num      float64 `json:"n,omitempty"`
+	Str      string  `json:"s,omitempty"`
+	InfoHash string  `json:"ih"`
+}
+
+func (a listKey) less(b listKey) bool {
+	if a.Num != b.Num {
+		return a.Num < b.Num
+	}
+	if a.Str != b.Str {
+		return a.Str < b.Str
+	}
+	return a.InfoHash < b.InfoHash
+}
+
+var listSorts = map[string]func(t *engine.Torrent) listKey{
+	"added":    func(t *engine.Torrent) listKey { return listKey{Num: float64(t.AddedAt.UnixNano())} },
+	"name":     func(t *engine.Torrent) listKey { return listKey{Str: strings.ToLower(t.Name)} },
+	"progress": func(t *engine.Torrent) listKey { return listKey{Num: float64(t.Percent)} },
+	"rate":     func(t *engine.Torrent) listKey { return listKey{Num: float64(t.DownloadRate)} },
+	"size":     func(t *engine.Torrent) listKey { return listKey{Num: float64(t.Size)} },
+	"ratio":    func(t *engine.Torrent) listKey { return listKey{Num: float64(t.Ratio)} },
+}
+
+// listCursor continues a list after the last torrent of a page, so
+// pages don't skip or repeat torrents when others are added or removed
+type listCursor struct {
+	Sort  string  `json:"sort"`
+	After listKey `json:"after"`
+}
+
+func (c listCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// listQuery is a parsed torrent list query
+type listQuery struct {
+	filter engine.TorrentFilter
+	sort   string
+	desc   bool
+	limit  int
+	offset int
+	cursor *listCursor
+	fields []string
+}
+
+// listPage describes the page of a torrent list
+type listPage struct {
+	total      int //matching torrents
+	nextCursor string
+}
+
+// parseListQuery reads a list query of torrents, which are encoded as
+// item. Invalid parameters are returned as details.
+func parseListQuery(v url.Values, item reflect.Type) (listQuery, map[string]string) {
+	q := listQuery{
+		filter: engine.TorrentFilter{
+			State:    v.Get("state"),
+			Tag:      v.Get("tag"),
+			Category: v.Get("category"),
+			Name:     v.Get("name"),
+		},
+		sort: v.Get("sort"),
+	}
+	invalid := map[string]string{}
+	if q.sort == "" {
+		q.sort = "added"
+	}
+	if _, ok := listSorts[strings.TrimPrefix(q.sort, "-")]; !ok {
+		invalid["sort"] = fmt.Sprintf("unknown sort (%s)", q.sort)
+	}
+	q.desc = strings.HasPrefix(q.sort, "-")
+	for name, n := range map[string]*int{"limit": &q.limit, "offset": &q.offset} {
+		s := v.Get(name)
+		if s == "" {
+			continue
+		}
+		i, err := strconv.Atoi(s)
+		if err != nil || i < 0 {
+			invalid[name] = "must be a whole number"
+		}
+		*n = i
+	}
+	if q.limit > maxListLimit {
+		invalid["limit"] = fmt.Sprintf("must be at most %d", maxListLimit)
+	}
+	if s := v.Get("cursor"); s != "" {
+		c := &listCursor{}
+		if b, err := base64.RawURLEncoding.DecodeString(s); err != nil || json.Unmarshal(b, c) != nil {
+			invalid["cursor"] = "malformed cursor"
+		} else if c.Sort != q.sort {
+			invalid["cursor"] = "cursor was returned with another sort"
+		} else if q.offset > 0 {
+			invalid["cursor"] = "cursor and offset can't both be used"
+		}
+		q.cursor = c
+	}
+	if s := v.Get("fields"); s != "" {
+		known := openAPISchemas{}.object(item)["properties"].(map[string]interface{})
+		for _, f := range strings.Split(s, ",") {
+			f = strings.TrimSpace(f)
+			if _, ok := known[f]; !ok {
+				invalid["fields"] = fmt.Sprintf("unknown field (%s)", f)
+			}
+			q.fields = append(q.fields, f)
+		}
+	}
+	return q, invalid
+}
+
+// listTorrents returns the page of torrents selected by the query
+// and the fields to return, writing an error when the query is invalid
+func (s *Server) listTorrents(w http.ResponseWriter, r *http.Request, item reflect.Type) ([]*engine.Torrent, listPage, []string, bool) {
+	q, invalid := parseListQuery(r.URL.Query(), item)
+	torrents, err := s.engine.FindTorrents(q.filter)
+	if err != nil {
+		invalid["state"] = err.Error()
+	}
+	if len(invalid) > 0 {
+		writeErrorDetails(w, http.StatusBadRequest, "Invalid list query", invalid)
+		return nil, listPage{}, nil, false
+	}
+
+	torrents, page := q.page(torrents)
+	return torrents, page, q.fields, true
+}
+
+// page sorts the torrents and returns the page selected by the query
+func (q listQuery) page(torrents []*engine.Torrent) ([]*engine.Torrent, listPage) {
+	key := listSorts[strings.TrimPrefix(q.sort, "-")]
+	type entry struct {
+		t   *engine.Torrent
+		key listKey
+	}
+	entries := []entry{}
+	for _, t := range torrents {
+		k := key(t)
+		k.InfoHash = t.InfoHash
+		entries = append(entries, entry{t, k})
+	}
+	before := func(a, b listKey) bool {
+		if q.desc {
+			return b.less(a)
+		}
+		return a.less(b)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return before(entries[i].key, entries[j].key)
+	})
+
+	page := listPage{total: len(entries)}
+	if q.cursor != nil {
+		i := sort.Search(len(entries), func(i int) bool {
+			return before(q.cursor.After, entries[i].key)
+		})
+		entries = entries[i:]
+	} else if q.offset < len(entries) {
+		entries = entries[q.offset:]
+	} else {
+		entries = nil
+	}
+	if q.limit > 0 && len(entries) > q.limit {
+		entries = entries[:q.limit]
+		page.nextCursor = listCursor{Sort: q.sort, After: entries[q.limit-1].key}.String()
+	}
+	torrents = []*engine.Torrent{}
+	for _, e := range entries {
+		torrents = append(torrents, e.t)
+	}
+	return torrents, page
+}
+
+// selectFields encodes items with only the given JSON fields
+func selectFields(items interface{}, fields []string) []map[string]json.RawMessage {
+	b, _ := json.Marshal(items)
+	selected := []map[string]json.RawMessage{}
+	json.Unmarshal(b, &selected)
+	for _, item := range selected {
+		for name := range item {
+			if !contains(fields, name) {
+				delete(item, name)
+			}
+		}
+	}
+	return selected
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpillora/cloud-torrent/engine"
+)
+
+// listTestTorrents are named a to e, added in that order, with sizes
+// which tie for b and d
+func listTestTorrents() []*engine.Torrent {
+	added := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	ts := []*engine.Torrent{}
+	for i, size := range []int64{30, 10, 50, 10, 20} {
+		ts = append(ts, &engine.Torrent{
+			InfoHash: strings.Repeat(string(rune('f'-i)), 40),
+			Name:     string(rune('a' + i)),
+			Size:     size,
+			AddedAt:  added.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	return ts
+}
+
+func parseTestQuery(t *testing.T, query string) listQuery {
+	v, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, invalid := parseListQuery(v, reflect.TypeOf(torrentV1{}))
+	if len(invalid) > 0 {
+		t.Fatalf("%s: invalid %v", query, invalid)
+	}
+	return q
+}
+
+func names(ts []*engine.Torrent) string {
+	s := ""
+	for _, t := range ts {
+		s += t.Name
+	}
+	return s
+}
+
+func TestListSort(t *testing.T) {
+	for query, want := range map[string]string{
+		"":            "abcde",
+		"sort=-added": "edcba",
+		"sort=name":   "abcde",
+		"sort=-name":  "edcba",
+		//b and d tie, ordered by infohash (d is lower)
+		"sort=size":  "dbeac",
+		"sort=-size": "caebd",
+	} {
+		ts, page := parseTestQuery(t, query).page(listTestTorrents())
+		if got := names(ts); got != want {
+			t.Errorf("%q: order %s, want %s", query, got, want)
+		}
+		if page.total != 5 || page.nextCursor != "" {
+			t.Errorf("%q: page %+v", query, page)
+		}
+	}
+}
+
+func TestListLimit(t *testing.T) {
+	for query, want := range map[string]string{
+		"limit=2":          "ab",
+		"limit=2&offset=2": "cd",
+		"offset=4":         "e",
+		"offset=9":         "",
+		"limit=9":          "abcde",
+	} {
+		ts, page := parseTestQuery(t, query).page(listTestTorrents())
+		if got := names(ts); got != want {
+			t.Errorf("%q: got %s, want %s", query, got, want)
+		}
+		if page.total != 5 {
+			t.Errorf("%q: total %d", query, page.total)
+		}
+	}
+}
+
+func TestListCursor(t *testing.T) {
+	//x and y are added after the first page, each sorts before the
+	//cursor in one direction and is only returned in the other
+	for sort, want := range map[string]string{
+		"size":  "db" + "ea" + "yc",
+		"-size": "ca" + "eb" + "dx",
+	} {
+		ts := listTestTorrents()
+		got := ""
+		query := "limit=2&sort=" + sort
+		for pages := 0; ; pages++ {
+			if pages > 5 {
+				t.Fatalf("%s: the cursor does not end", sort)
+			}
+			page, p := parseTestQuery(t, query).page(ts)
+			got += names(page)
+			if p.nextCursor == "" {
+				break
+			}
+			query = "limit=2&sort=" + sort + "&cursor=" + p.nextCursor
+			if pages == 0 {
+				ts = append(ts,
+					&engine.Torrent{InfoHash: strings.Repeat("1", 40), Name: "x", Size: 5},
+					&engine.Torrent{InfoHash: strings.Repeat("2", 40), Name: "y", Size: 40},
+				)
+			}
+		}
+		if got != want {
+			t.Errorf("%s: pages returned %s, want %s", sort, got, want)
+		}
+	}
+}
+
+func TestListQueryInvalid(t *testing.T) {
+	cursor := listCursor{Sort: "name"}.String()
+	for query, param := range map[string]string{
+		"sort=colour":                         "sort",
+		"limit=-1":                            "limit",
+		"limit=5000":                          "limit",
+		"offset=x":                            "offset",
+		"cursor=!":                            "cursor",
+		"sort=size&cursor=" + cursor:          "cursor",
+		"sort=name&offset=1&cursor=" + cursor: "cursor",
+		"fields=name,colour":                  "fields",
+	} {
+		v, _ := url.ParseQuery(query)
+		if _, invalid := parseListQuery(v, reflect.TypeOf(torrentV1{})); invalid[param] == "" {
+			t.Errorf("%q: %s not rejected (%v)", query, param, invalid)
+		}
+	}
+}
+
+func TestSelectFields(t *testing.T) {
+	q := parseTestQuery(t, "fields=infoHash, name")
+	ts, _ := q.page(listTestTorrents())
+	items := []torrentV1{}
+	for _, t := range ts[:2] {
+		items = append(items, newTorrentV1(t, false))
+	}
+	b, _ := json.Marshal(selectFields(items, q.fields))
+	want := `[{"infoHash":"ffffffffffffffffffffffffffffffffffffffff","name":"a"},` +
+		`{"infoHash":"eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee","name":"b"}]`
+	if string(b) != want {
+		t.Fatalf("selected %s, want %s", b, want)
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the document is built from the routes and the Go types of their
+// bodies. The query parameters and body types of a route are declared
+// by hand in restRoutes, keep them in line with what its handler reads
+// and writes.
+
+var pathParam = regexp.MustCompile(`\{(\w+)(\.\.\.)?\}`)
+
+// pathParams describes the path parameters by name
+var pathParams = map[string]map[string]interface{}{
+	"infohash": {
+		"description": "hex encoded infohash of the torrent",
+		"schema":      map[string]interface{}{"type": "string", "pattern": "^[0-9a-fA-F]{40}$"},
+	},
+	"id": {
+		"description": "id of the feed",
+		"schema":      map[string]interface{}{"type": "string"},
+	},
+	"path": {
+		"description": "path of the file inside the torrent",
+		"schema":      map[string]interface{}{"type": "string"},
+	},
+}
+
+// getOpenAPI returns an OpenAPI 3 description of the RESTful API,
+// including the /api aliases
+func (s *Server) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.openAPI())
+}
+
+func (s *Server) openAPI() map[string]interface{} {
+	schemas := openAPISchemas{}
+	paths := map[string]map[string]interface{}{}
+	add := func(rt route, op map[string]interface{}) {
+		path := pathParam.ReplaceAllString(rt.path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(rt.method)] = op
+	}
+	for _, rt := range s.restRoutes() {
+		add(rt, schemas.operation(rt))
+		alias := rt.alias()
+		op := schemas.operation(alias)
+		op["operationId"] = handlerName(rt.handler) + "Alias"
+		if !rt.keepAlias {
+			op["deprecated"] = true
+		}
+		add(alias, op)
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   s.Title + " API",
+			"version": s.state.Stats.Version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "Error, its code is derived from the HTTP status",
+					"content":     jsonContent(schemas.of(reflect.TypeOf(apiError{}))),
+				},
+			},
+		},
+	}
+}
+
+// openAPISchemas holds the schemas of named struct types
+type openAPISchemas map[string]interface{}
+
+func (schemas openAPISchemas) operation(rt route) map[string]interface{} {
+	op := map[string]interface{}{
+		"operationId": handlerName(rt.handler),
+		"summary":     rt.summary,
+	}
+	params := []interface{}{}
+	for _, m := range pathParam.FindAllStringSubmatch(rt.path, -1) {
+		p := map[string]interface{}{"name": m[1], "in": "path", "required": true}
+		for k, v := range pathParams[m[1]] {
+			p[k] = v
+		}
+		params = append(params, p)
+	}
+	for _, q := range rt.query {
+		params = append(params, map[string]interface{}{
+			"name":        q.name,
+			"in":          "query",
+			"description": q.description,
+			"schema":      map[string]interface{}{"type": q.typ},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if rt.request != nil {
+		content := jsonContent(schemas.of(reflect.TypeOf(rt.request)))
+		if rt.upload {
+			//options are read from the other form fields, or the query string
+			content["multipart/form-data"] = map[string]interface{}{
+				"schema": map[string]interface{}{
+					"type": "object",
+					"additionalProperties": map[string]interface{}{
+						"type": "string", "format": "binary",
+					},
+				},
+			}
+			content["application/x-bittorrent"] = binaryContent()
+		}
+		op["requestBody"] = map[string]interface{}{"required": true, "content": content}
+	}
+	success := map[string]interface{}{"description": "Success"}
+	switch res := rt.response.(type) {
+	case nil:
+	case contentType:
+		success["content"] = map[string]interface{}{string(res): binaryContent()}
+	default:
+		schema := schemas.of(reflect.TypeOf(res))
+		if rt.upload {
+			schema = map[string]interface{}{"oneOf": []interface{}{
+				schema, schemas.of(reflect.TypeOf(uploadResponse{})),
+			}}
+		}
+		success["content"] = jsonContent(schema)
+	}
+	status := rt.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	op["responses"] = map[string]interface{}{
+		strconv.Itoa(status): success,
+		"default":            map[string]interface{}{"$ref": "#/components/responses/Error"},
+	}
+	return op
+}
+
+// of returns the schema of t, named struct types are referenced
+func (schemas openAPISchemas) of(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemas.of(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemas.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return schemas.object(t)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = nil //recursive types reference themselves
+			schemas[t.Name()] = schemas.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	//interfaces may hold anything
+	return map[string]interface{}{}
+}
+
+// object returns the schema of a struct, with its fields named like
+// encoding/json names them
+func (schemas openAPISchemas) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	schemas.fields(t, props)
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+func (schemas openAPISchemas) fields(t reflect.Type, props map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			schemas.fields(f.Type, props)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemas.of(f.Type)
+	}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+func binaryContent() map[string]interface{} {
+	return map[string]interface{}{
+		"schema": map[string]interface{}{"type": "string", "format": "binary"},
+	}
+}
+
+// handlerName is the name of the method behind a handler, which
+// clients use to name their functions
+func handlerName(h http.HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+	//the version is already in the path
+	name = strings.TrimSuffix(name, "V1")
+	return name[strings.LastIndex(name, ".")+1:]
+}
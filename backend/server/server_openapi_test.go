@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpillora/cloud-torrent/engine"
+)
+
+// pathExamples fill the path parameters of test requests
+var pathExamples = map[string]string{
+	"infohash": strings.Repeat("ab", 20),
+	"id":       "feed",
+	"path":     "dir/file.mkv",
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	s := &Server{}
+	mux := s.restRouter().(*http.ServeMux)
+	paths := s.openAPI()["paths"].(map[string]map[string]interface{})
+
+	operations := 0
+	for _, ops := range paths {
+		operations += len(ops)
+	}
+	handlers := s.restHandlers()
+	if operations != len(handlers) {
+		t.Errorf("%d operations documented, %d routes served", operations, len(handlers))
+	}
+	ids := map[string]bool{}
+	for pattern := range handlers {
+		method, path, _ := strings.Cut(pattern, " ")
+		op, ok := paths[pathParam.ReplaceAllString(path, "{$1}")][strings.ToLower(method)].(map[string]interface{})
+		if !ok {
+			t.Errorf("%s is not documented", pattern)
+			continue
+		}
+		id := op["operationId"].(string)
+		if ids[id] {
+			t.Errorf("%s: duplicate operationId %s", pattern, id)
+		}
+		ids[id] = true
+		//the pattern is the one the mux picks
+		url := pathParam.ReplaceAllStringFunc(path, func(p string) string {
+			return pathExamples[pathParam.FindStringSubmatch(p)[1]]
+		})
+		if _, matched := mux.Handler(httptest.NewRequest(method, url, nil)); matched != pattern {
+			t.Errorf("%s %s is routed to %q", method, url, matched)
+		}
+		deprecated, _ := op["deprecated"].(bool)
+		if deprecated != (!strings.HasPrefix(path, "/api/v1/") && path != "/api/openapi.json") {
+			t.Errorf("%s: deprecated is %v", pattern, deprecated)
+		}
+	}
+}
+
+func TestOpenAPIDeprecation(t *testing.T) {
+	s := &Server{engine: engine.New()}
+	router := s.restRouter()
+	for path, want := range map[string]string{
+		"/api/v1/openapi.json": "",
+		"/api/openapi.json":    "",
+		"/api/v1/config":       "",
+		"/api/config":          "true",
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d", path, w.Code)
+		}
+		if got := w.Header().Get("Deprecation"); got != want {
+			t.Errorf("%s: Deprecation %q, want %q", path, got, want)
+		}
+	}
+}
+
+// TestOpenAPISchemas encodes every body type with all its fields set
+// and checks the JSON against the documented schema
+func TestOpenAPISchemas(t *testing.T) {
+	s := &Server{}
+	for _, rt := range s.restRoutes() {
+		for _, r := range []route{rt, rt.alias()} {
+			for _, body := range []interface{}{r.request, r.response} {
+				if _, ok := body.(contentType); ok || body == nil {
+					continue
+				}
+				typ := reflect.TypeOf(body)
+				name := r.method + " " + r.path + " " + typ.String()
+				v := reflect.New(typ).Elem()
+				fill(v, nil)
+				b, err := json.Marshal(v.Interface())
+				if err != nil {
+					t.Fatalf("%s: %s", name, err)
+				}
+				//decoding must give back the same value
+				back := reflect.New(typ)
+				if err := json.Unmarshal(b, back.Interface()); err != nil {
+					t.Fatalf("%s: %s", name, err)
+				}
+				if again, _ := json.Marshal(back.Elem().Interface()); !bytes.Equal(b, again) {
+					t.Errorf("%s: round trip changed %s to %s", name, b, again)
+				}
+				var decoded interface{}
+				json.Unmarshal(b, &decoded)
+				schemas := openAPISchemas{}
+				checkSchema(t, name, schemas, schemas.of(typ), decoded, nil)
+			}
+		}
+	}
+}
+
+// fill sets every field of v, structs inside themselves are left empty
+func fill(v reflect.Value, parents []reflect.Type) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem(), parents)
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			v.Set(reflect.ValueOf(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+			return
+		}
+		for _, p := range parents {
+			if p == v.Type() {
+				return
+			}
+		}
+		parents = append(parents, v.Type())
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				fill(v.Field(i), parents)
+			}
+		}
+	case reflect.Slice:
+		s := reflect.MakeSlice(v.Type(), 1, 1)
+		fill(s.Index(0), parents)
+		v.Set(s)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		k := reflect.New(v.Type().Key()).Elem()
+		e := reflect.New(v.Type().Elem()).Elem()
+		fill(k, parents)
+		fill(e, parents)
+		m.SetMapIndex(k, e)
+		v.Set(m)
+	case reflect.Interface:
+		v.Set(reflect.ValueOf("any"))
+	case reflect.String:
+		v.SetString("text")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	}
+}
+
+// checkSchema checks decoded JSON against a schema, every set field
+// must be documented and every documented field must be set, except
+// in the empty structs fill leaves inside themselves
+func checkSchema(t *testing.T, name string, schemas openAPISchemas, schema map[string]interface{}, v interface{}, parents []string) {
+	recursive := false
+	if ref, ok := schema["$ref"].(string); ok {
+		for _, p := range parents {
+			recursive = recursive || p == ref
+		}
+		parents = append(parents, ref)
+		schema = schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{})
+	}
+	if v == nil {
+		return
+	}
+	ok := true
+	switch schema["type"] {
+	case "object":
+		var m map[string]interface{}
+		if m, ok = v.(map[string]interface{}); !ok {
+			break
+		}
+		if props, isStruct := schema["properties"].(map[string]interface{}); isStruct {
+			for k, val := range m {
+				if p, documented := props[k]; !documented {
+					t.Errorf("%s: field %s is not documented", name, k)
+				} else {
+					checkSchema(t, name+"."+k, schemas, p.(map[string]interface{}), val, parents)
+				}
+			}
+			for k := range props {
+				if _, set := m[k]; !set && !recursive {
+					t.Errorf("%s: documented field %s is not encoded", name, k)
+				}
+			}
+		}
+		if ap, isMap := schema["additionalProperties"].(map[string]interface{}); isMap {
+			for k, val := range m {
+				checkSchema(t, name+"."+k, schemas, ap, val, parents)
+			}
+		}
+	case "array":
+		var a []interface{}
+		if a, ok = v.([]interface{}); ok {
+			for _, val := range a {
+				checkSchema(t, name+"[]", schemas, schema["items"].(map[string]interface{}), val, parents)
+			}
+		}
+	case "string":
+		_, ok = v.(string)
+	case "integer":
+		var n float64
+		n, ok = v.(float64)
+		ok = ok && n == math.Trunc(n)
+	case "number":
+		_, ok = v.(float64)
+	case "boolean":
+		_, ok = v.(bool)
+	}
+	if !ok {
+		t.Errorf("%s: %v is not a %s", name, v, schema["type"])
+	}
+}
@@ -1,11 +1,17 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/anacrolix/torrent"
 	"github.com/jpillora/cloud-torrent/engine"
 )
 
@@ -21,67 +27,110 @@ func (s *Server) handleRestAPI(w http.ResponseWriter, r *http.Request) {
 		allowedHeaders = "Content-Type, Accept, ngrok-skip-browser-warning"
 	}
 	w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
+	w.Header().Set("Access-Control-Expose-Headers", "Deprecation, Link, X-Total-Count, X-Next-Cursor")
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	path := strings.TrimPrefix(r.URL.Path, "/api")
-
-	switch {
-	case path == "/torrents" && r.Method == "GET":
-		s.getTorrents(w, r)
-	case path == "/torrent" && r.Method == "POST":
-		s.addTorrent(w, r)
-	case strings.HasPrefix(path, "/torrent/") && strings.HasSuffix(path, "/files") && r.Method == "GET":
-		s.getTorrentFiles(w, r)
-	case strings.HasPrefix(path, "/torrent/") && strings.HasSuffix(path, "/files") && r.Method == "POST":
-		s.updateTorrentFiles(w, r)
-	case strings.HasPrefix(path, "/torrent/") && strings.HasSuffix(path, "/start") && r.Method == "POST":
-		s.startTorrent(w, r)
-	case strings.HasPrefix(path, "/torrent/") && strings.HasSuffix(path, "/stop") && r.Method == "POST":
-		s.stopTorrent(w, r)
-	case strings.HasPrefix(path, "/torrent/") && r.Method == "DELETE":
-		s.deleteTorrent(w, r)
-	case path == "/config" && r.Method == "GET":
-		s.getConfig(w, r)
-	case path == "/config" && r.Method == "PUT":
-		s.updateConfig(w, r)
-	case path == "/files" && r.Method == "GET":
-		s.getFiles(w, r)
-	default:
-		http.Error(w, "Not Found", http.StatusNotFound)
-	}
+	s.rest.ServeHTTP(w, r)
 }
 
-// getTorrents returns all torrents
+// getTorrents returns an ordered page of torrents, the total and the
+// next cursor are sent as headers
 func (s *Server) getTorrents(w http.ResponseWriter, r *http.Request) {
+	torrents, page, fields, ok := s.listTorrents(w, r, reflect.TypeOf(engine.Torrent{}))
+	if !ok {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.total))
+	if page.nextCursor != "" {
+		w.Header().Set("X-Next-Cursor", page.nextCursor)
+	}
+	if len(fields) > 0 {
+		json.NewEncoder(w).Encode(selectFields(torrents, fields))
+		return
+	}
+	json.NewEncoder(w).Encode(torrents)
+}
 
-	torrents := s.state.Torrents
+// statusResponse is the body of successful actions
+type statusResponse struct {
+	Status   string   `json:"status"`
+	InfoHash string   `json:"infoHash,omitempty"` // of the added torrent
+	Kept     []string `json:"kept,omitempty"`     // shared files not deleted with the torrent
+}
 
-	json.NewEncoder(w).Encode(torrents)
+var success = statusResponse{Status: "success"}
+
+// addOptions are the add-time options of a torrent
+type addOptions struct {
+	Stream        bool     `json:"stream"`
+	Paused        bool     `json:"paused"`
+	SavePath      string   `json:"savePath"`
+	Category      string   `json:"category"`
+	Tags          []string `json:"tags"`
+	Files         []int    `json:"files"`     // indexes of the files to download
+	FileGlobs     []string `json:"fileGlobs"` // patterns matching the files to download
+	Sequential    bool     `json:"sequential"`
+	DownloadLimit int      `json:"downloadLimit"` // bytes per second
+	UploadLimit   int      `json:"uploadLimit"`   // bytes per second
+}
+
+func (o addOptions) engine() engine.AddOptions {
+	return engine.AddOptions{
+		Stream:        o.Stream,
+		Paused:        o.Paused,
+		SavePath:      o.SavePath,
+		Category:      o.Category,
+		Tags:          o.Tags,
+		Files:         o.Files,
+		FileGlobs:     o.FileGlobs,
+		Sequential:    o.Sequential,
+		DownloadLimit: o.DownloadLimit,
+		UploadLimit:   o.UploadLimit,
+	}
 }
 
-// addTorrent adds a new torrent from magnet link
+// addTorrentRequest adds a magnet link or a remote .torrent file
+type addTorrentRequest struct {
+	Magnet string `json:"magnet"`
+	URL    string `json:"url"` // remote .torrent file
+	addOptions
+}
+
+// addTorrent adds a new torrent from magnet link or remote URL, or
+// uploaded .torrent files
 func (s *Server) addTorrent(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Magnet string `json:"magnet"`
+	if isUpload(r) {
+		s.uploadTorrents(w, r)
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	var req addTorrentRequest
+
+	if !decodeBody(w, r, &req) {
 		return
 	}
 
-	if req.Magnet == "" {
-		http.Error(w, "Magnet link is required", http.StatusBadRequest)
+	if req.Magnet == "" && req.URL == "" {
+		writeError(w, http.StatusBadRequest, "Magnet link or URL is required")
 		return
 	}
 
-	if err := s.engine.NewMagnet(req.Magnet); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to add torrent: %s", err), http.StatusBadRequest)
+	res := success
+	if req.URL != "" {
+		infohash, err := s.addTorrentURL(req.URL, req.addOptions.engine())
+		if err != nil {
+			writeEngineError(w, "add torrent", err)
+			return
+		}
+		res.InfoHash = infohash
+	} else if err := s.engine.AddMagnet(req.Magnet, req.addOptions.engine()); err != nil {
+		writeEngineError(w, "add torrent", err)
 		return
 	}
 
@@ -89,70 +138,79 @@ func (s *Server) addTorrent(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	json.NewEncoder(w).Encode(res)
 }
 
-// deleteTorrent removes a torrent by infohash
+// deleteTorrent removes a torrent by infohash, and its files with ?deleteData=true
 func (s *Server) deleteTorrent(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/api/torrent/")
-	infohash := path
-
-	if infohash == "" {
-		http.Error(w, "Infohash is required", http.StatusBadRequest)
+	infohash, ok := pathInfohash(w, r)
+	if !ok {
 		return
 	}
 
-	if err := s.engine.DeleteTorrent(infohash); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to delete torrent: %s", err), http.StatusBadRequest)
+	deleteData := false
+	if v := r.URL.Query().Get("deleteData"); v != "" {
+		var err error
+		if deleteData, err = strconv.ParseBool(v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid deleteData (%s)", v))
+			return
+		}
+	}
+
+	res := success
+	if deleteData {
+		//files shared with another torrent are kept
+		kept, err := s.engine.DeleteTorrentData(infohash)
+		if err != nil {
+			writeEngineError(w, "delete torrent", err)
+			return
+		}
+		res.Kept = kept
+	} else if err := s.engine.DeleteTorrent(infohash); err != nil {
+		writeEngineError(w, "delete torrent", err)
 		return
 	}
 
 	s.state.Push()
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	json.NewEncoder(w).Encode(res)
 }
 
 // startTorrent starts a torrent by infohash
 func (s *Server) startTorrent(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/api/torrent/")
-	infohash := strings.TrimSuffix(path, "/start")
-
-	if infohash == "" {
-		http.Error(w, "Infohash is required", http.StatusBadRequest)
+	infohash, ok := pathInfohash(w, r)
+	if !ok {
 		return
 	}
 
 	if err := s.engine.StartTorrent(infohash); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to start torrent: %s", err), http.StatusBadRequest)
+		writeEngineError(w, "start torrent", err)
 		return
 	}
 
 	s.state.Push()
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	json.NewEncoder(w).Encode(success)
 }
 
 // stopTorrent stops a torrent by infohash
 func (s *Server) stopTorrent(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/api/torrent/")
-	infohash := strings.TrimSuffix(path, "/stop")
-
-	if infohash == "" {
-		http.Error(w, "Infohash is required", http.StatusBadRequest)
+	infohash, ok := pathInfohash(w, r)
+	if !ok {
 		return
 	}
 
 	if err := s.engine.StopTorrent(infohash); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to stop torrent: %s", err), http.StatusBadRequest)
+		writeEngineError(w, "stop torrent", err)
 		return
 	}
 
 	s.state.Push()
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	json.NewEncoder(w).Encode(success)
 }
 
 // getConfig returns the current configuration
@@ -168,13 +226,12 @@ func (s *Server) getConfig(w http.ResponseWriter, r *http.Request) {
 func (s *Server) updateConfig(w http.ResponseWriter, r *http.Request) {
 	var config engine.Config
 
-	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeBody(w, r, &config) {
 		return
 	}
 
 	if err := s.reconfigure(config); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to update config: %s", err), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Failed to update config: %s", err))
 		return
 	}
 
@@ -197,17 +254,14 @@ func (s *Server) getFiles(w http.ResponseWriter, r *http.Request) {
 
 // getTorrentFiles returns detailed file list for a specific torrent
 func (s *Server) getTorrentFiles(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/api/torrent/")
-	infohash := strings.TrimSuffix(path, "/files")
-
-	if infohash == "" {
-		http.Error(w, "Infohash is required", http.StatusBadRequest)
+	infohash, ok := pathInfohash(w, r)
+	if !ok {
 		return
 	}
 
 	torrent, err := s.engine.GetTorrentFiles(infohash)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get torrent files: %s", err), http.StatusBadRequest)
+		writeEngineError(w, "get torrent files", err)
 		return
 	}
 
@@ -215,45 +269,216 @@ func (s *Server) getTorrentFiles(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(torrent)
 }
 
+// updateFilesRequest starts or stops downloading files of a torrent
+type updateFilesRequest struct {
+	FilePaths []string `json:"filePaths"`
+	Action    string   `json:"action"` // "start" or "stop"
+}
+
 // updateTorrentFiles updates which files should be downloaded
 func (s *Server) updateTorrentFiles(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/api/torrent/")
-	infohash := strings.TrimSuffix(path, "/files")
-
-	if infohash == "" {
-		http.Error(w, "Infohash is required", http.StatusBadRequest)
+	infohash, ok := pathInfohash(w, r)
+	if !ok {
 		return
 	}
 
-	var req struct {
-		FilePaths []string `json:"filePaths"`
-		Action    string   `json:"action"` // "start" or "stop"
-	}
+	var req updateFilesRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeBody(w, r, &req) {
 		return
 	}
 
 	if len(req.FilePaths) == 0 {
-		http.Error(w, "FilePaths is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "FilePaths is required")
 		return
 	}
 
 	if req.Action != "start" && req.Action != "stop" {
-		http.Error(w, "Action must be 'start' or 'stop'", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Action must be 'start' or 'stop'")
 		return
 	}
 
 	download := req.Action == "start"
 
 	if err := s.engine.UpdateFileSelection(infohash, req.FilePaths, download); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to update file selection: %s", err), http.StatusBadRequest)
+		writeEngineError(w, "update file selection", err)
 		return
 	}
 
 	s.state.Push()
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	json.NewEncoder(w).Encode(success)
+}
+
+// getTorrentMetainfo returns the bencoded .torrent file of a torrent
+func (s *Server) getTorrentMetainfo(w http.ResponseWriter, r *http.Request) {
+	infohash, ok := pathInfohash(w, r)
+	if !ok {
+		return
+	}
+
+	mi, err := s.engine.Metainfo(infohash)
+	if err != nil {
+		writeEngineError(w, "get metainfo", err)
+		return
+	}
+
+	name := infohash
+	if info, err := mi.UnmarshalInfo(); err == nil && info.BestName() != "" {
+		name = info.BestName()
+	}
+	w.Header().Set("Content-Type", "application/x-bittorrent")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".torrent"))
+	mi.Write(w)
+}
+
+type magnetResponse struct {
+	Magnet string `json:"magnet"`
+}
+
+// getTorrentMagnet returns a magnet URI for a torrent
+func (s *Server) getTorrentMagnet(w http.ResponseWriter, r *http.Request) {
+	infohash, ok := pathInfohash(w, r)
+	if !ok {
+		return
+	}
+
+	magnet, err := s.engine.Magnet(infohash)
+	if err != nil {
+		writeEngineError(w, "get magnet", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(magnetResponse{Magnet: magnet})
+}
+
+// getTorrentPeers returns the connected peers of a torrent
+func (s *Server) getTorrentPeers(w http.ResponseWriter, r *http.Request) {
+	infohash, ok := pathInfohash(w, r)
+	if !ok {
+		return
+	}
+
+	peers, err := s.engine.GetPeers(infohash)
+	if err != nil {
+		writeEngineError(w, "get peers", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(peers)
+}
+
+// streamTorrentFile serves a file of a torrent while it downloads,
+// supporting range requests for seeking
+func (s *Server) streamTorrentFile(w http.ResponseWriter, r *http.Request) {
+	infohash, ok := pathInfohash(w, r)
+	if !ok {
+		return
+	}
+	file := r.PathValue("path")
+
+	if file == "" {
+		writeError(w, http.StatusBadRequest, "File path is required")
+		return
+	}
+
+	reader, f, err := s.engine.OpenFile(infohash, file)
+	if err != nil {
+		writeEngineError(w, "open file", err)
+		return
+	}
+	defer reader.Close()
+
+	http.ServeContent(w, r, filepath.Base(f.Path), time.Time{}, contextReader{reader, r.Context()})
+}
+
+// contextReader stops blocking reads once the client disconnects
+type contextReader struct {
+	torrent.Reader
+	ctx context.Context
+}
+
+func (c contextReader) Read(b []byte) (int, error) {
+	return c.ReadContext(c.ctx, b)
+}
+
+// getNetworkDiagnostics reports whether the incoming port is reachable
+func (s *Server) getNetworkDiagnostics(w http.ResponseWriter, r *http.Request) {
+	d, err := s.engine.NetworkDiagnostics()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to run diagnostics: %s", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// createRequest shares a path inside the download directory
+type createRequest struct {
+	Path        string   `json:"path"`
+	PieceLength int64    `json:"pieceLength"`
+	Trackers    []string `json:"trackers"`
+	WebSeeds    []string `json:"webSeeds"`
+	Private     bool     `json:"private"`
+	Comment     string   `json:"comment"`
+	Seed        bool     `json:"seed"` // start seeding the created torrent
+}
+
+// createTorrent builds a .torrent from a path inside the download
+// directory. Hashing stops if the client goes away, and only one
+// torrent is created at a time.
+func (s *Server) createTorrent(w http.ResponseWriter, r *http.Request) {
+	var req createRequest
+
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	if req.Path == "" {
+		writeError(w, http.StatusBadRequest, "Path is required")
+		return
+	}
+	//checked before hashing, which may take a while
+	if c := s.engine.Config(); req.Seed && (!c.EnableSeeding || !c.EnableUpload) {
+		writeError(w, http.StatusConflict, "Seeding is disabled, enable uploads and seeding to seed created torrents")
+		return
+	}
+
+	//dldir is absolute
+	dldir := s.engine.Config().DownloadDirectory
+	file := filepath.Join(dldir, req.Path)
+	//only allow sharing inside the dl dir
+	if !strings.HasPrefix(file, dldir+string(filepath.Separator)) {
+		writeError(w, http.StatusBadRequest, "Path must be inside the download directory")
+		return
+	}
+
+	mi, err := engine.CreateTorrent(r.Context(), file, engine.CreateOptions{
+		PieceLength: req.PieceLength,
+		Trackers:    req.Trackers,
+		WebSeeds:    req.WebSeeds,
+		Private:     req.Private,
+		Comment:     req.Comment,
+	})
+	if err != nil {
+		writeEngineError(w, "create torrent", err)
+		return
+	}
+
+	if req.Seed {
+		if err := s.engine.SeedTorrent(mi, filepath.Dir(file)); err != nil {
+			writeEngineError(w, "seed torrent", err)
+			return
+		}
+		s.state.Push()
+	}
+
+	name := filepath.Base(file) + ".torrent"
+	w.Header().Set("Content-Type", "application/x-bittorrent")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	mi.Write(w)
 }
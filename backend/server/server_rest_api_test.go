@@ -0,0 +1,20 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jpillora/cloud-torrent/engine"
+)
+
+func TestCreateTorrentSeedDisabled(t *testing.T) {
+	s := &Server{engine: engine.New()}
+	w := httptest.NewRecorder()
+	body := `{"path":"Show","seed":true}`
+	s.createTorrent(w, httptest.NewRequest("POST", "/api/v1/create", strings.NewReader(body)))
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "Seeding is disabled") {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+}
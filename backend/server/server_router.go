@@ -0,0 +1,252 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jpillora/cloud-torrent/engine"
+)
+
+// route is an endpoint of the RESTful API. Its request and response
+// values are only used for their types, which describe the endpoint
+// in /api/openapi.json.
+type route struct {
+	method, path   string
+	handler        http.HandlerFunc
+	legacy         http.HandlerFunc // serves the /api alias when its response differs
+	legacyResponse interface{}      // of the legacy handler
+	keepAlias      bool             // the /api alias is not deprecated
+	summary        string
+	query          []queryParam
+	request        interface{} // JSON body, nil if none
+	upload         bool        // also accepts .torrent files, see uploadTorrents
+	status         int         // on success, 200 if unset
+	response       interface{} // JSON body, or the contentType of other bodies
+}
+
+type queryParam struct {
+	name, typ, description string
+}
+
+// contentType is the media type of a response which isn't JSON
+type contentType string
+
+func (s *Server) restRoutes() []route {
+	return []route{
+		{method: "GET", path: "/api/v1/torrents", handler: s.getTorrentsV1,
+			legacy: s.getTorrents, legacyResponse: []*engine.Torrent{},
+			summary:  "List torrents, filtered, sorted and paged",
+			query:    listParams,
+			response: torrentListV1{}},
+		{method: "POST", path: "/api/v1/torrents/bulk", handler: s.bulkTorrents,
+			summary: "Apply an action to many torrents",
+			request: bulkRequest{}, response: bulkResponse{}},
+		{method: "POST", path: "/api/v1/torrent", handler: s.addTorrent,
+			summary: "Add a magnet link, a remote .torrent file or uploaded .torrent files",
+			query:   []queryParam{{"name", "string", "file name of a raw .torrent upload"}},
+			request: addTorrentRequest{}, upload: true, status: http.StatusCreated, response: statusResponse{}},
+		{method: "DELETE", path: "/api/v1/torrent/{infohash}", handler: s.deleteTorrent,
+			summary:  "Remove a torrent",
+			query:    []queryParam{{"deleteData", "boolean", "also delete its files, except those shared with other torrents"}},
+			response: statusResponse{}},
+		{method: "GET", path: "/api/v1/torrent/{infohash}/files", handler: s.getTorrentFilesV1,
+			legacy: s.getTorrentFiles, legacyResponse: &engine.Torrent{},
+			summary:  "Get a torrent with its files",
+			response: torrentV1{}},
+		{method: "POST", path: "/api/v1/torrent/{infohash}/files", handler: s.updateTorrentFiles,
+			summary: "Start or stop downloading files of a torrent",
+			request: updateFilesRequest{}, response: statusResponse{}},
+		{method: "GET", path: "/api/v1/torrent/{infohash}/metainfo", handler: s.getTorrentMetainfo,
+			summary:  "Download the .torrent file of a torrent",
+			response: contentType("application/x-bittorrent")},
+		{method: "GET", path: "/api/v1/torrent/{infohash}/magnet", handler: s.getTorrentMagnet,
+			summary:  "Get the magnet link of a torrent",
+			response: magnetResponse{}},
+		{method: "GET", path: "/api/v1/torrent/{infohash}/peers", handler: s.getTorrentPeersV1,
+			legacy: s.getTorrentPeers, legacyResponse: []*engine.Peer{},
+			summary:  "List the connected peers of a torrent",
+			response: []peerV1{}},
+		{method: "POST", path: "/api/v1/torrent/{infohash}/start", handler: s.startTorrent,
+			summary:  "Start a torrent",
+			response: statusResponse{}},
+		{method: "POST", path: "/api/v1/torrent/{infohash}/stop", handler: s.stopTorrent,
+			summary:  "Stop a torrent",
+			response: statusResponse{}},
+		{method: "GET", path: "/api/v1/torrent/{infohash}/stream/{path...}", handler: s.streamTorrentFile,
+			summary:  "Stream a file of a torrent while it downloads, with range requests",
+			response: contentType("application/octet-stream")},
+		{method: "GET", path: "/api/v1/config", handler: s.getConfigV1,
+			legacy: s.getConfig, legacyResponse: engine.Config{},
+			summary:  "Get the configuration",
+			response: configV1{}},
+		{method: "PUT", path: "/api/v1/config", handler: s.updateConfigV1,
+			legacy: s.updateConfig, legacyResponse: engine.Config{},
+			summary: "Replace the configuration",
+			request: configV1{}, response: configV1{}},
+		{method: "GET", path: "/api/v1/files", handler: s.getFilesV1,
+			legacy: s.getFiles, legacyResponse: &fsNode{},
+			summary:  "Get the tree of the download directory",
+			response: fsNodeV1{}},
+		{method: "POST", path: "/api/v1/create", handler: s.createTorrent,
+			summary: "Create a .torrent file from the download directory",
+			request: createRequest{}, response: contentType("application/x-bittorrent")},
+		{method: "GET", path: "/api/v1/feeds", handler: s.getFeeds,
+			summary:  "List feed subscriptions",
+			response: []feedV1{}},
+		{method: "POST", path: "/api/v1/feeds", handler: s.addFeed,
+			summary: "Subscribe to a feed",
+			request: feedRequestV1{}, status: http.StatusCreated, response: feedV1{}},
+		{method: "PUT", path: "/api/v1/feeds/{id}", handler: s.updateFeed,
+			summary: "Change a feed subscription",
+			request: feedRequestV1{}, response: feedV1{}},
+		{method: "DELETE", path: "/api/v1/feeds/{id}", handler: s.deleteFeed,
+			summary:  "Unsubscribe from a feed",
+			response: statusResponse{}},
+		{method: "POST", path: "/api/v1/feeds/{id}/refresh", handler: s.refreshFeed,
+			summary:  "Poll a feed now",
+			response: refreshResponse{}},
+		{method: "GET", path: "/api/v1/diagnostics/network", handler: s.getNetworkDiagnosticsV1,
+			legacy: s.getNetworkDiagnostics, legacyResponse: &engine.NetworkDiagnostics{},
+			summary:  "Check whether peers can connect",
+			response: networkDiagnosticsV1{}},
+		{method: "GET", path: "/api/v1/openapi.json", handler: s.getOpenAPI, keepAlias: true,
+			summary:  "Get this OpenAPI document",
+			response: map[string]interface{}{}},
+	}
+}
+
+// alias returns the /api alias of an /api/v1 route
+func (rt route) alias() route {
+	alias := rt
+	alias.path = strings.Replace(rt.path, "/api/v1/", "/api/", 1)
+	if rt.legacy != nil {
+		alias.handler = rt.legacy
+		alias.response = rt.legacyResponse
+	}
+	return alias
+}
+
+// restHandlers returns the handlers of the routes and their aliases
+// by mux pattern
+func (s *Server) restHandlers() map[string]http.HandlerFunc {
+	handlers := map[string]http.HandlerFunc{}
+	for _, rt := range s.restRoutes() {
+		handlers[rt.method+" "+rt.path] = rt.handler
+		alias := rt.alias()
+		if rt.keepAlias {
+			handlers[alias.method+" "+alias.path] = alias.handler
+		} else {
+			handlers[alias.method+" "+alias.path] = deprecated(alias.handler)
+		}
+	}
+	return handlers
+}
+
+// restRouter routes the RESTful API, path parameters are read with
+// r.PathValue. Each /api/v1 route is also served under /api until
+// clients move over. Unmatched requests get JSON errors too.
+func (s *Server) restRouter() http.Handler {
+	mux := http.NewServeMux()
+	for pattern, h := range s.restHandlers() {
+		mux.HandleFunc(pattern, h)
+	}
+	//the mux would answer in plain text
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		allowed := []string{}
+		for _, method := range []string{"GET", "POST", "PUT", "DELETE"} {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			if _, pattern := mux.Handler(probe); pattern != "/api/" {
+				allowed = append(allowed, method)
+			}
+		}
+		if len(allowed) == 0 {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("No such endpoint: %s", r.URL.Path))
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("Method %s not allowed", r.Method))
+	})
+	return mux
+}
+
+// apiError is the body of every RESTful API error
+type apiError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// errorCodes are the machine readable codes of the statuses in use
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "invalid_request",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusBadGateway:            "upstream_error",
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeErrorDetails(w, status, message, nil)
+}
+
+// writeErrorDetails writes a JSON error, details explain which parts
+// of the request were invalid
+func writeErrorDetails(w http.ResponseWriter, status int, message string, details interface{}) {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Code: code, Message: message, Details: details})
+}
+
+// writeEngineError writes an error returned by the engine, unknown
+// torrents and files are 404s, and requests the torrent is not in a
+// state to handle are 409s
+func writeEngineError(w http.ResponseWriter, action string, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, engine.ErrNotFound) {
+		status = http.StatusNotFound
+	} else if errors.Is(err, engine.ErrConflict) {
+		status = http.StatusConflict
+	}
+	writeError(w, status, fmt.Sprintf("Failed to %s: %s", action, err))
+}
+
+// decodeBody decodes the JSON request body into v
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeErrorDetails(w, http.StatusBadRequest, "Invalid request body", err.Error())
+		return false
+	}
+	return true
+}
+
+// pathInfohash returns the lowercased {infohash} path parameter
+func pathInfohash(w http.ResponseWriter, r *http.Request) (string, bool) {
+	infohash := r.PathValue("infohash")
+	if !validInfohash(infohash) {
+		writeErrorDetails(w, http.StatusBadRequest, "Invalid infohash", map[string]string{
+			"infohash": "must be 40 hexadecimal characters",
+		})
+		return "", false
+	}
+	return strings.ToLower(infohash), true
+}
+
+func validInfohash(infohash string) bool {
+	if len(infohash) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(infohash)
+	return err == nil
+}
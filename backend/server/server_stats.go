@@ -3,6 +3,7 @@ package server
 import (
 	"runtime"
 
+	"github.com/jpillora/cloud-torrent/engine"
 	velox "github.com/jpillora/velox/go"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
@@ -18,11 +19,23 @@ type stats struct {
 	MemoryTotal int64   `json:"memoryTotal"`
 	GoMemory    int64   `json:"goMemory"`
 	GoRoutines  int     `json:"goRoutines"`
+	//engine
+	BlocklistRanges  int    `json:"blocklistRanges"`
+	BlocklistBlocked int64  `json:"blocklistBlocked"`
+	DHTServers       int    `json:"dhtServers"`
+	DHTNodes         int    `json:"dhtNodes"`
+	DHTGoodNodes     int    `json:"dhtGoodNodes"`
+	DHTBadNodes      int    `json:"dhtBadNodes"`
+	PortMapStatus    string `json:"portMapStatus"`
+	PortMapProtocol  string `json:"portMapProtocol"`
+	ExternalAddress  string `json:"externalAddress"`
+	PortMapError     string `json:"portMapError"`
+	DiskFull         bool   `json:"diskFull"`
 	//internal
 	pusher velox.Pusher
 }
 
-func (s *stats) loadStats(diskDir string) {
+func (s *stats) loadStats(diskDir string, es engine.Stats) {
 	//count cpu cycles between last count
 	if percents, err := cpu.Percent(0, false); err == nil && len(percents) == 1 {
 		s.CPU = percents[0]
@@ -43,6 +56,19 @@ func (s *stats) loadStats(diskDir string) {
 	s.GoMemory = int64(memStats.Alloc)
 	//count current number of goroutines
 	s.GoRoutines = runtime.NumGoroutine()
+	//count blocklist ranges and blocked peers
+	s.BlocklistRanges = es.BlocklistRanges
+	s.BlocklistBlocked = es.BlocklistBlocked
+	//count dht routing table nodes
+	s.DHTServers = es.DHTServers
+	s.DHTNodes = es.DHTNodes
+	s.DHTGoodNodes = es.DHTGoodNodes
+	s.DHTBadNodes = es.DHTBadNodes
+	s.PortMapStatus = es.PortMapStatus
+	s.PortMapProtocol = es.PortMapProtocol
+	s.ExternalAddress = es.ExternalAddress
+	s.PortMapError = es.PortMapError
+	s.DiskFull = es.DiskFull
 	//done
 	s.Set = true
 	s.pusher.Push()
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jpillora/cloud-torrent/engine"
+)
+
+const (
+	torrentFileMaxSize = 10 << 20
+	uploadMaxSize      = 64 << 20
+	//the rest of a multipart upload is buffered on disk
+	uploadMaxMemory = 16 << 20
+)
+
+// uploadResult is the outcome of adding one uploaded file
+type uploadResult struct {
+	File     string `json:"file"`
+	InfoHash string `json:"infoHash,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type uploadResponse struct {
+	Added   int            `json:"added"`
+	Results []uploadResult `json:"results"`
+}
+
+// isUpload is true when the request body holds .torrent files
+// instead of a JSON magnet request
+func isUpload(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data" || mediaType == "application/x-bittorrent"
+}
+
+// uploadTorrents adds the .torrent files of a multipart form, or of
+// a raw application/x-bittorrent body. Options are read from the
+// other form fields, or from the query string.
+func (s *Server) uploadTorrents(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, uploadMaxSize)
+	type upload struct {
+		name string
+		data []byte
+		err  error
+	}
+	var uploads []upload
+	values := r.URL.Query()
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		if err := r.ParseMultipartForm(uploadMaxMemory); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid upload: %s", err))
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		for k, v := range r.MultipartForm.Value {
+			values[k] = append(values[k], v...)
+		}
+		fields := []string{}
+		for field := range r.MultipartForm.File {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			for _, fh := range r.MultipartForm.File[field] {
+				u := upload{name: fh.Filename}
+				if fh.Size > torrentFileMaxSize {
+					u.err = fmt.Errorf("Torrent file too large (max %d MB)", torrentFileMaxSize>>20)
+				} else if f, err := fh.Open(); err != nil {
+					u.err = err
+				} else {
+					u.data, u.err = ioutil.ReadAll(f)
+					f.Close()
+				}
+				uploads = append(uploads, u)
+			}
+		}
+	} else {
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, torrentFileMaxSize))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid upload: %s", err))
+			return
+		}
+		uploads = append(uploads, upload{name: values.Get("name"), data: data})
+	}
+	if len(uploads) == 0 {
+		writeError(w, http.StatusBadRequest, "No torrent files uploaded")
+		return
+	}
+	opts, err := optionsFromValues(values)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results := []uploadResult{}
+	added, conflicts := 0, 0
+	for _, u := range uploads {
+		result := uploadResult{File: u.name}
+		if u.err == nil {
+			result.InfoHash, u.err = s.addTorrentFile(u.data, opts.engine())
+		}
+		if u.err != nil {
+			result.Error = u.err.Error()
+			if errors.Is(u.err, engine.ErrConflict) {
+				conflicts++
+			}
+		} else {
+			added++
+		}
+		results = append(results, result)
+	}
+	if added > 0 {
+		s.state.Push()
+	}
+
+	status := http.StatusCreated
+	if added == 0 && conflicts == len(uploads) {
+		status = http.StatusConflict
+	} else if added == 0 {
+		status = http.StatusBadRequest
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(uploadResponse{Added: added, Results: results})
+}
+
+// optionsFromValues reads add options from form or query values,
+// named like the JSON fields. Lists may be repeated or comma separated,
+// except file patterns which may contain commas.
+func optionsFromValues(v url.Values) (addOptions, error) {
+	o := addOptions{
+		SavePath:  v.Get("savePath"),
+		Category:  v.Get("category"),
+		Tags:      splitValues(v["tags"]),
+		FileGlobs: v["fileGlobs"],
+	}
+	bools := map[string]*bool{"stream": &o.Stream, "paused": &o.Paused, "sequential": &o.Sequential}
+	for name, b := range bools {
+		if s := v.Get(name); s != "" {
+			var err error
+			if *b, err = strconv.ParseBool(s); err != nil {
+				return o, fmt.Errorf("Invalid %s (%s)", name, s)
+			}
+		}
+	}
+	ints := map[string]*int{"downloadLimit": &o.DownloadLimit, "uploadLimit": &o.UploadLimit}
+	for name, n := range ints {
+		if s := v.Get(name); s != "" {
+			var err error
+			if *n, err = strconv.Atoi(s); err != nil {
+				return o, fmt.Errorf("Invalid %s (%s)", name, s)
+			}
+		}
+	}
+	for _, s := range splitValues(v["files"]) {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return o, fmt.Errorf("Invalid file index (%s)", s)
+		}
+		o.Files = append(o.Files, i)
+	}
+	return o, nil
+}
+
+func splitValues(values []string) []string {
+	out := []string{}
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
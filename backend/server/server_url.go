@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const (
+	urlTimeout      = 30 * time.Second
+	urlMaxRedirects = 5
+)
+
+// content types servers use for .torrent files
+var torrentContentTypes = map[string]bool{
+	"":                           true,
+	"application/x-bittorrent":   true,
+	"application/octet-stream":   true,
+	"binary/octet-stream":        true,
+	"application/force-download": true,
+	"application/x-download":     true,
+}
+
+// fetchTorrentURL downloads a remote .torrent file, or returns the
+// magnet it redirects to
+func (s *Server) fetchTorrentURL(rawurl string) (data []byte, magnet string, err error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, "", fmt.Errorf("Invalid URL: %s", err)
+	}
+	if u.Scheme == "magnet" {
+		return nil, rawurl, nil
+	}
+	block := s.engine.Config().BlockPrivateURLs
+	if err := checkURL(u, block); err != nil {
+		return nil, "", err
+	}
+	client := s.httpClient(urlTimeout)
+	if block && s.engine.Config().ProxyURL == "" {
+		//check the address actually dialed, a name may resolve
+		//differently by then. a configured proxy dials for us, so
+		//only the names checked before each request apply.
+		transport := client.Transport.(*http.Transport)
+		transport.Proxy = nil
+		dialer := &net.Dialer{Timeout: urlTimeout, Control: blockPrivate}
+		transport.DialContext = dialer.DialContext
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme == "magnet" {
+			return http.ErrUseLastResponse
+		}
+		if len(via) >= urlMaxRedirects {
+			return fmt.Errorf("Too many redirects")
+		}
+		return checkURL(req.URL, block)
+	}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return nil, "", fmt.Errorf("Invalid remote torrent URL: %s", err)
+	}
+	defer resp.Body.Close()
+	if loc := resp.Header.Get("Location"); resp.StatusCode/100 == 3 && loc != "" {
+		if l, err := url.Parse(loc); err == nil && l.Scheme == "magnet" {
+			return nil, loc, nil
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("Remote torrent URL returned %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !torrentContentTypes[mediaType] {
+		return nil, "", fmt.Errorf("Not a torrent file (content type %s)", mediaType)
+	}
+	if resp.ContentLength > torrentFileMaxSize {
+		return nil, "", fmt.Errorf("Torrent file too large (max %d MB)", torrentFileMaxSize>>20)
+	}
+	data, err = ioutil.ReadAll(io.LimitReader(resp.Body, torrentFileMaxSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to download remote torrent: %s", err)
+	}
+	if len(data) > torrentFileMaxSize {
+		return nil, "", fmt.Errorf("Torrent file too large (max %d MB)", torrentFileMaxSize>>20)
+	}
+	return data, "", nil
+}
+
+// checkURL allows http(s) URLs, resolving the host when private
+// addresses are blocked
+func checkURL(u *url.URL, block bool) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Unsupported URL scheme (%s)", u.Scheme)
+	}
+	if !block {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), urlTimeout)
+	defer cancel()
+	addrs, err := lookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("Invalid remote torrent URL: %s", err)
+	}
+	for _, addr := range addrs {
+		if privateIP(addr.IP) {
+			return fmt.Errorf("Private addresses are blocked (%s)", u.Hostname())
+		}
+	}
+	return nil
+}
+
+// lookupIPAddr resolves hosts for checkURL, replaced by tests
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// blockPrivate is a net.Dialer Control func refusing private addresses
+func blockPrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+		return fmt.Errorf("Private addresses are blocked (%s)", host)
+	}
+	return nil
+}
+
+// privateNets are not covered by the net.IP checks: carrier-grade NAT
+// and "this network"
+var privateNets = []*net.IPNet{
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+	{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+}
+
+func privateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
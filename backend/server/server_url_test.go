@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jpillora/cloud-torrent/engine"
+)
+
+func TestPrivateIP(t *testing.T) {
+	for ip, private := range map[string]bool{
+		"127.0.0.1":       true,
+		"10.1.2.3":        true,
+		"100.64.0.1":      true,
+		"100.127.255.1":   true,
+		"0.1.2.3":         true,
+		"169.254.169.254": true,
+		"::1":             true,
+		"fd00::1":         true,
+		"100.128.0.1":     false,
+		"203.0.113.1":     false,
+		"2001:db8::1":     false,
+	} {
+		if privateIP(net.ParseIP(ip)) != private {
+			t.Errorf("%s private: %v", ip, !private)
+		}
+	}
+}
+
+// newURLServer returns a server fetching URLs with the given config
+func newURLServer(t *testing.T, c engine.Config) *Server {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.IncomingPort = l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	c.DownloadDirectory = t.TempDir()
+	c.DisableDHT, c.DisableUTP, c.DisableIPv6, c.DisablePortForwarding = true, true, true, true
+	e := engine.New()
+	if err := e.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	return &Server{engine: e}
+}
+
+// resolve replaces the resolver of checkURL with hosts, by name
+func resolve(t *testing.T, hosts map[string]string) {
+	lookupIPAddr = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		ip, ok := hosts[host]
+		if !ok {
+			return nil, fmt.Errorf("no such host %s", host)
+		}
+		return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+	}
+	t.Cleanup(func() { lookupIPAddr = net.DefaultResolver.LookupIPAddr })
+}
+
+func TestFetchPrivateURL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-bittorrent")
+		fmt.Fprint(w, "d4:infod4:name1:aee")
+	}))
+	defer ts.Close()
+
+	s := newURLServer(t, engine.Config{})
+	if _, _, err := s.fetchTorrentURL(ts.URL); err != nil {
+		t.Fatalf("fetch with private URLs allowed: %s", err)
+	}
+	s = newURLServer(t, engine.Config{BlockPrivateURLs: true})
+	if _, _, err := s.fetchTorrentURL(ts.URL); err == nil || !strings.Contains(err.Error(), "Private addresses are blocked") {
+		t.Fatalf("private URL fetched: %v", err)
+	}
+	//the name is checked as public but dials a private address
+	resolve(t, map[string]string{"127.0.0.1": "203.0.113.1"})
+	if _, _, err := s.fetchTorrentURL(ts.URL); err == nil || !strings.Contains(err.Error(), "Private addresses are blocked") {
+		t.Fatalf("private address dialed: %v", err)
+	}
+}
+
+func TestFetchPrivateRedirect(t *testing.T) {
+	//a proxy dials for the client, only the names of each request are
+	//checked, including those it is redirected to
+	fetched := []string{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = append(fetched, r.URL.Host)
+		if r.URL.Host == "public.example" {
+			http.Redirect(w, r, "http://private.example/a.torrent", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-bittorrent")
+		fmt.Fprint(w, "d4:infod4:name1:aee")
+	}))
+	defer proxy.Close()
+	resolve(t, map[string]string{"public.example": "203.0.113.1", "private.example": "10.0.0.1"})
+
+	s := newURLServer(t, engine.Config{BlockPrivateURLs: true, ProxyURL: proxy.URL})
+	if _, _, err := s.fetchTorrentURL("http://public.example/a.torrent"); err == nil || !strings.Contains(err.Error(), "Private addresses are blocked") {
+		t.Fatalf("redirect to a private address followed: %v", err)
+	}
+	if len(fetched) != 1 {
+		t.Errorf("fetched %q", fetched)
+	}
+	s = newURLServer(t, engine.Config{ProxyURL: proxy.URL})
+	if _, _, err := s.fetchTorrentURL("http://public.example/a.torrent"); err != nil || len(fetched) != 3 {
+		t.Fatalf("redirect with private URLs allowed: %v, fetched %q", err, fetched)
+	}
+}
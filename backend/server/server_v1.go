@@ -0,0 +1,466 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jpillora/cloud-torrent/engine"
+)
+
+// /api/v1 responses are copied into the types below instead of
+// encoding engine types, so engine fields are only exposed on purpose.
+// The /api paths are deprecated aliases which keep the old responses.
+
+// torrentV1 is a torrent as returned by /api/v1
+type torrentV1 struct {
+	InfoHash      string     `json:"infoHash"`
+	Name          string     `json:"name"`
+	Loaded        bool       `json:"loaded"` // metadata received
+	Size          int64      `json:"size"`
+	Downloaded    int64      `json:"downloaded"`
+	Uploaded      int64      `json:"uploaded"`
+	AddedAt       time.Time  `json:"addedAt"`
+	Percent       float32    `json:"percent"`
+	Ratio         float32    `json:"ratio"`        // uploaded per downloaded byte
+	DownloadRate  float32    `json:"downloadRate"` // bytes per second
+	UploadRate    float32    `json:"uploadRate"`   // bytes per second
+	Peers         int        `json:"peers"`
+	Started       bool       `json:"started"`
+	Stream        bool       `json:"stream"`
+	Error         string     `json:"error,omitempty"`
+	SavePath      string     `json:"savePath,omitempty"`
+	Category      string     `json:"category,omitempty"`
+	Tags          []string   `json:"tags"`
+	Sequential    bool       `json:"sequential"`
+	DownloadLimit int        `json:"downloadLimit"` // bytes per second, 0 is unlimited
+	UploadLimit   int        `json:"uploadLimit"`   // bytes per second, 0 is unlimited
+	Extract       *extractV1 `json:"extract,omitempty"`
+	Files         []fileV1   `json:"files,omitempty"` // only with the files of one torrent
+}
+
+// extractV1 is the archive extraction of a torrent
+type extractV1 struct {
+	Status  string  `json:"status"` // extracting, extracted or failed
+	Percent float32 `json:"percent"`
+	Error   string  `json:"error,omitempty"`
+}
+
+type fileV1 struct {
+	Path      string  `json:"path"`
+	Size      int64   `json:"size"`
+	Chunks    int     `json:"chunks"`
+	Completed int     `json:"completed"` // chunks
+	Percent   float32 `json:"percent"`
+	Selected  bool    `json:"selected"` // for download
+}
+
+type peerV1 struct {
+	Address      string  `json:"address"`
+	Client       string  `json:"client"`
+	Network      string  `json:"network"`
+	Source       string  `json:"source"`
+	Encryption   string  `json:"encryption"` // rc4, obfuscated or plaintext
+	DownloadRate float32 `json:"downloadRate"`
+}
+
+type torrentListV1 struct {
+	Torrents   []torrentV1 `json:"torrents"`
+	Total      int         `json:"total"`                // matching torrents, on all pages
+	NextCursor string      `json:"nextCursor,omitempty"` // when there are more pages
+}
+
+func newTorrentV1(t *engine.Torrent, files bool) torrentV1 {
+	v := torrentV1{
+		InfoHash:      t.InfoHash,
+		Name:          t.Name,
+		Loaded:        t.Loaded,
+		Size:          t.Size,
+		Downloaded:    t.Downloaded,
+		Uploaded:      t.Uploaded,
+		AddedAt:       t.AddedAt,
+		Percent:       t.Percent,
+		Ratio:         t.Ratio,
+		DownloadRate:  t.DownloadRate,
+		UploadRate:    t.UploadRate,
+		Peers:         t.Peers,
+		Started:       t.Started,
+		Stream:        t.Stream,
+		Error:         t.Error,
+		SavePath:      t.SavePath,
+		Category:      t.Category,
+		Tags:          append([]string{}, t.Tags...),
+		Sequential:    t.Sequential,
+		DownloadLimit: t.DownloadLimit,
+		UploadLimit:   t.UploadLimit,
+	}
+	if t.ExtractStatus != "" {
+		v.Extract = &extractV1{
+			Status:  t.ExtractStatus,
+			Percent: t.ExtractPercent,
+			Error:   t.ExtractError,
+		}
+	}
+	if files {
+		v.Files = []fileV1{}
+		for _, f := range t.Files {
+			if f == nil {
+				continue
+			}
+			v.Files = append(v.Files, fileV1{
+				Path:      f.Path,
+				Size:      f.Size,
+				Chunks:    f.Chunks,
+				Completed: f.Completed,
+				Percent:   f.Percent,
+				Selected:  f.Priority,
+			})
+		}
+	}
+	return v
+}
+
+// configV1 is the configuration as read and written by /api/v1
+type configV1 struct {
+	AutoStart             bool     `json:"autoStart"`
+	DisableEncryption     bool     `json:"disableEncryption"`
+	EncryptionPolicy      string   `json:"encryptionPolicy"`
+	DownloadDirectory     string   `json:"downloadDirectory"`
+	IncompleteDirectory   string   `json:"incompleteDirectory"`
+	WatchDirectory        string   `json:"watchDirectory"`
+	PartExtension         bool     `json:"partExtension"`
+	Storage               string   `json:"storage"`
+	StreamCacheMB         int      `json:"streamCacheMb"`
+	MinFreeSpaceMB        int      `json:"minFreeSpaceMb"`
+	EnableUpload          bool     `json:"enableUpload"`
+	EnableSeeding         bool     `json:"enableSeeding"`
+	IncomingPort          int      `json:"incomingPort"`
+	DisablePortForwarding bool     `json:"disablePortForwarding"`
+	Blocklist             string   `json:"blocklist"`
+	ProxyURL              string   `json:"proxyUrl"`
+	ProxyOnly             bool     `json:"proxyOnly"`
+	DisableDHT            bool     `json:"disableDht"`
+	DisablePEX            bool     `json:"disablePex"`
+	DisableUTP            bool     `json:"disableUtp"`
+	DisableTCP            bool     `json:"disableTcp"`
+	DisableIPv6           bool     `json:"disableIpv6"`
+	DHTBootstrapNodes     []string `json:"dhtBootstrapNodes"`
+	ExtractArchives       bool     `json:"extractArchives"`
+	DeleteArchives        bool     `json:"deleteArchives"`
+	BlockPrivateURLs      bool     `json:"blockPrivateUrls"`
+}
+
+func newConfigV1(c engine.Config) configV1 {
+	return configV1{
+		AutoStart:             c.AutoStart,
+		DisableEncryption:     c.DisableEncryption,
+		EncryptionPolicy:      c.EncryptionPolicy,
+		DownloadDirectory:     c.DownloadDirectory,
+		IncompleteDirectory:   c.IncompleteDirectory,
+		WatchDirectory:        c.WatchDirectory,
+		PartExtension:         c.PartExtension,
+		Storage:               c.Storage,
+		StreamCacheMB:         c.StreamCacheMB,
+		MinFreeSpaceMB:        c.MinFreeSpaceMB,
+		EnableUpload:          c.EnableUpload,
+		EnableSeeding:         c.EnableSeeding,
+		IncomingPort:          c.IncomingPort,
+		DisablePortForwarding: c.DisablePortForwarding,
+		Blocklist:             c.Blocklist,
+		ProxyURL:              c.ProxyURL,
+		ProxyOnly:             c.ProxyOnly,
+		DisableDHT:            c.DisableDHT,
+		DisablePEX:            c.DisablePEX,
+		DisableUTP:            c.DisableUTP,
+		DisableTCP:            c.DisableTCP,
+		DisableIPv6:           c.DisableIPv6,
+		DHTBootstrapNodes:     append([]string{}, c.DHTBootstrapNodes...),
+		ExtractArchives:       c.ExtractArchives,
+		DeleteArchives:        c.DeleteArchives,
+		BlockPrivateURLs:      c.BlockPrivateURLs,
+	}
+}
+
+func (c configV1) engineConfig() engine.Config {
+	return engine.Config{
+		AutoStart:             c.AutoStart,
+		DisableEncryption:     c.DisableEncryption,
+		EncryptionPolicy:      c.EncryptionPolicy,
+		DownloadDirectory:     c.DownloadDirectory,
+		IncompleteDirectory:   c.IncompleteDirectory,
+		WatchDirectory:        c.WatchDirectory,
+		PartExtension:         c.PartExtension,
+		Storage:               c.Storage,
+		StreamCacheMB:         c.StreamCacheMB,
+		MinFreeSpaceMB:        c.MinFreeSpaceMB,
+		EnableUpload:          c.EnableUpload,
+		EnableSeeding:         c.EnableSeeding,
+		IncomingPort:          c.IncomingPort,
+		DisablePortForwarding: c.DisablePortForwarding,
+		Blocklist:             c.Blocklist,
+		ProxyURL:              c.ProxyURL,
+		ProxyOnly:             c.ProxyOnly,
+		DisableDHT:            c.DisableDHT,
+		DisablePEX:            c.DisablePEX,
+		DisableUTP:            c.DisableUTP,
+		DisableTCP:            c.DisableTCP,
+		DisableIPv6:           c.DisableIPv6,
+		DHTBootstrapNodes:     c.DHTBootstrapNodes,
+		ExtractArchives:       c.ExtractArchives,
+		DeleteArchives:        c.DeleteArchives,
+		BlockPrivateURLs:      c.BlockPrivateURLs,
+	}
+}
+
+// networkDiagnosticsV1 describes whether peers can connect
+type networkDiagnosticsV1 struct {
+	IncomingPort        int        `json:"incomingPort"`
+	ListenAddrs         []string   `json:"listenAddrs"`
+	Listening           bool       `json:"listening"`
+	IncomingConnections int64      `json:"incomingConnections"`
+	LastIncoming        *time.Time `json:"lastIncoming,omitempty"`
+	IncomingRecently    bool       `json:"incomingRecently"`
+	PortMapStatus       string     `json:"portMapStatus"`
+	ExternalAddress     string     `json:"externalAddress,omitempty"`
+	PortStatus          string     `json:"portStatus"` // open or unknown
+	PortCheckError      string     `json:"portCheckError,omitempty"`
+	HairpinCheck        string     `json:"hairpinCheck,omitempty"` // ok or failed, inconclusive
+}
+
+// feedV1 is a feed subscription, without the items it has already
+// processed
+type feedV1 struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	URL         string       `json:"url"`
+	Interval    int          `json:"interval"` // minutes between polls
+	Enabled     bool         `json:"enabled"`
+	Rules       []feedRuleV1 `json:"rules"`
+	Dedupe      bool         `json:"dedupe"` // skip episodes already downloaded
+	LastChecked time.Time    `json:"lastChecked"`
+	LastError   string       `json:"lastError"`
+}
+
+// feedRuleV1 matches item titles by regular expressions
+type feedRuleV1 struct {
+	Include string `json:"include"`
+	Exclude string `json:"exclude"`
+}
+
+func newFeedV1(f *feed) feedV1 {
+	return feedV1{
+		ID:          f.ID,
+		Name:        f.Name,
+		URL:         f.URL,
+		Interval:    f.Interval,
+		Enabled:     f.Enabled,
+		Rules:       newFeedRulesV1(f.Rules),
+		Dedupe:      f.Dedupe,
+		LastChecked: f.LastChecked,
+		LastError:   f.LastError,
+	}
+}
+
+func newFeedRulesV1(rules []feedRule) []feedRuleV1 {
+	res := []feedRuleV1{}
+	for _, r := range rules {
+		res = append(res, feedRuleV1{Include: r.Include, Exclude: r.Exclude})
+	}
+	return res
+}
+
+// feedRequestV1 subscribes to a feed or changes a subscription
+type feedRequestV1 struct {
+	Name     string       `json:"name"`
+	URL      string       `json:"url"`
+	Interval int          `json:"interval"` // minutes between polls
+	Enabled  bool         `json:"enabled"`
+	Rules    []feedRuleV1 `json:"rules"`
+	Dedupe   bool         `json:"dedupe"` // skip episodes already downloaded
+}
+
+func (r feedRequestV1) feedSettings() feedSettings {
+	f := feedSettings{
+		Name:     r.Name,
+		URL:      r.URL,
+		Interval: r.Interval,
+		Enabled:  r.Enabled,
+		Dedupe:   r.Dedupe,
+	}
+	for _, rule := range r.Rules {
+		f.Rules = append(f.Rules, feedRule{Include: rule.Include, Exclude: rule.Exclude})
+	}
+	return f
+}
+
+// fsNodeV1 is a file or directory of the download directory
+type fsNodeV1 struct {
+	Name     string     `json:"name"`
+	Size     int64      `json:"size"` // of all files, for directories
+	Modified time.Time  `json:"modified"`
+	Partial  bool       `json:"partial"` // still downloading
+	Children []fsNodeV1 `json:"children,omitempty"`
+}
+
+func newFsNodeV1(n *fsNode) fsNodeV1 {
+	v := fsNodeV1{
+		Name:     n.Name,
+		Size:     n.Size,
+		Modified: n.Modified,
+		Partial:  n.Partial,
+	}
+	for _, c := range n.Children {
+		v.Children = append(v.Children, newFsNodeV1(c))
+	}
+	return v
+}
+
+// getTorrentsV1 returns an ordered page of torrents, without their files
+func (s *Server) getTorrentsV1(w http.ResponseWriter, r *http.Request) {
+	torrents, page, fields, ok := s.listTorrents(w, r, reflect.TypeOf(torrentV1{}))
+	if !ok {
+		return
+	}
+
+	res := torrentListV1{
+		Torrents:   []torrentV1{},
+		Total:      page.total,
+		NextCursor: page.nextCursor,
+	}
+	for _, t := range torrents {
+		res.Torrents = append(res.Torrents, newTorrentV1(t, false))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(fields) > 0 {
+		//the outer torrents field replaces the embedded one
+		json.NewEncoder(w).Encode(struct {
+			torrentListV1
+			Torrents []map[string]json.RawMessage `json:"torrents"`
+		}{res, selectFields(res.Torrents, fields)})
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// getTorrentFilesV1 returns a torrent with its files
+func (s *Server) getTorrentFilesV1(w http.ResponseWriter, r *http.Request) {
+	infohash, ok := pathInfohash(w, r)
+	if !ok {
+		return
+	}
+
+	t, err := s.engine.GetTorrentFiles(infohash)
+	if err != nil {
+		writeEngineError(w, "get torrent files", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newTorrentV1(t, true))
+}
+
+// getTorrentPeersV1 returns the connected peers of a torrent
+func (s *Server) getTorrentPeersV1(w http.ResponseWriter, r *http.Request) {
+	infohash, ok := pathInfohash(w, r)
+	if !ok {
+		return
+	}
+
+	peers, err := s.engine.GetPeers(infohash)
+	if err != nil {
+		writeEngineError(w, "get peers", err)
+		return
+	}
+
+	res := []peerV1{}
+	for _, p := range peers {
+		res = append(res, peerV1{
+			Address:      p.Address,
+			Client:       p.Client,
+			Network:      p.Network,
+			Source:       p.Source,
+			Encryption:   p.Encryption,
+			DownloadRate: p.DownloadRate,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// getConfigV1 returns the current configuration
+func (s *Server) getConfigV1(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newConfigV1(s.engine.Config()))
+}
+
+// updateConfigV1 replaces the configuration
+func (s *Server) updateConfigV1(w http.ResponseWriter, r *http.Request) {
+	var config configV1
+
+	if !decodeBody(w, r, &config) {
+		return
+	}
+
+	if err := s.reconfigure(config.engineConfig()); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Failed to update config: %s", err))
+		return
+	}
+
+	s.state.Push()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newConfigV1(s.engine.Config()))
+}
+
+// getFilesV1 returns the tree of the download directory
+func (s *Server) getFilesV1(w http.ResponseWriter, r *http.Request) {
+	s.state.Lock()
+	root := fsNodeV1{}
+	if s.state.Downloads != nil {
+		root = newFsNodeV1(s.state.Downloads)
+	}
+	s.state.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(root)
+}
+
+// getNetworkDiagnosticsV1 reports whether the incoming port is reachable
+func (s *Server) getNetworkDiagnosticsV1(w http.ResponseWriter, r *http.Request) {
+	d, err := s.engine.NetworkDiagnostics()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to run diagnostics: %s", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(networkDiagnosticsV1{
+		IncomingPort:        d.IncomingPort,
+		ListenAddrs:         append([]string{}, d.ListenAddrs...),
+		Listening:           d.Listening,
+		IncomingConnections: d.IncomingConnections,
+		LastIncoming:        d.LastIncoming,
+		IncomingRecently:    d.IncomingRecently,
+		PortMapStatus:       d.PortMapStatus,
+		ExternalAddress:     d.ExternalAddress,
+		PortStatus:          d.PortStatus,
+		PortCheckError:      d.PortCheckError,
+		HairpinCheck:        d.HairpinCheck,
+	})
+}
+
+// deprecated serves an /api alias, pointing clients at the /api/v1
+// endpoint which replaces it
+func deprecated(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		successor := "/api/v1/" + strings.TrimPrefix(r.URL.EscapedPath(), "/api/")
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		h(w, r)
+	}
+}
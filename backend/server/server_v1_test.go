@@ -0,0 +1,37 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jpillora/cloud-torrent/engine"
+)
+
+// TestConfigV1 checks every engine setting survives the conversion,
+// new settings must be added to configV1 too
+func TestConfigV1(t *testing.T) {
+	c := engine.Config{}
+	fill(reflect.ValueOf(&c).Elem(), nil)
+	if got := newConfigV1(c).engineConfig(); !reflect.DeepEqual(got, c) {
+		t.Fatalf("config changed from\n%+v\nto\n%+v", c, got)
+	}
+}
+
+// TestFeedV1 checks every feed setting survives the conversions, new
+// settings must be added to feedV1 and feedRequestV1 too
+func TestFeedV1(t *testing.T) {
+	f := feed{}
+	fill(reflect.ValueOf(&f.feedSettings).Elem(), nil)
+	v := newFeedV1(&f)
+	req := feedRequestV1{
+		Name:     v.Name,
+		URL:      v.URL,
+		Interval: v.Interval,
+		Enabled:  v.Enabled,
+		Rules:    v.Rules,
+		Dedupe:   v.Dedupe,
+	}
+	if got := req.feedSettings(); !reflect.DeepEqual(got, f.feedSettings) {
+		t.Fatalf("feed changed from\n%+v\nto\n%+v", f.feedSettings, got)
+	}
+}
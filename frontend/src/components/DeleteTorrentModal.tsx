@@ -1,4 +1,4 @@
-import React from 'react';
+import React, { useState } from 'react';
 import { useAppDispatch, useAppSelector } from '../hooks/redux';
 import { closeDeleteTorrentModal } from '../store/slices/uiSlice';
 import { deleteTorrent, fetchTorrents } from '../store/slices/torrentsSlice';
@@ -8,15 +8,18 @@ const DeleteTorrentModal: React.FC = () => {
   const { deleteTorrentModalOpen, deleteTorrentHash } = useAppSelector((state) => state.ui);
   const torrents = useAppSelector((state) => state.torrents.items);
 
+  const [deleteData, setDeleteData] = useState(false);
+
   const selectedTorrent = torrents.find(t => t.InfoHash === deleteTorrentHash);
 
   const handleClose = () => {
+    setDeleteData(false);
     dispatch(closeDeleteTorrentModal());
   };
 
   const handleConfirmDelete = async () => {
     if (deleteTorrentHash) {
-      await dispatch(deleteTorrent(deleteTorrentHash));
+      await dispatch(deleteTorrent({ infoHash: deleteTorrentHash, deleteData }));
       dispatch(fetchTorrents());
       handleClose();
     }
@@ -51,6 +54,16 @@ const DeleteTorrentModal: React.FC = () => {
             <p className="text-xs text-[#8b7d6b]">
               This action cannot be undone. The torrent will be removed from your download list.
             </p>
+            {!selectedTorrent.Stream && (
+              <label className="mt-3 flex items-center gap-2 text-xs font-medium text-[#6c3a39]">
+                <input
+                  type="checkbox"
+                  checked={deleteData}
+                  onChange={(e) => setDeleteData(e.target.checked)}
+                />
+                Also delete downloaded files (files shared with other torrents are kept)
+              </label>
+            )}
           </div>
 
           {/* Action Buttons */}
@@ -1,5 +1,5 @@
 import axios, { AxiosError, AxiosResponse, InternalAxiosRequestConfig } from 'axios';
-import { FileNode } from '../types';
+import { ApiError, FileNode } from '../types';
 
 // Load API configuration
 let apiUrl = 'http://localhost:3000/api'; // Default fallback
@@ -42,7 +42,11 @@ api.interceptors.response.use(
   (response: AxiosResponse) => {
     return response;
   },
-  (error: AxiosError) => {
+  (error: AxiosError<ApiError>) => {
+    // Use the message of JSON API errors
+    if (error.response?.data?.message) {
+      error.message = error.response.data.message;
+    }
     // Silently handle errors when backend is not available
     // You can uncomment these for debugging when backend is connected
     // if (error.response) {
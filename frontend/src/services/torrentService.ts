@@ -1,5 +1,5 @@
 import api from './api';
-import { Torrent, UpdateFileSelectionRequest } from '../types';
+import { AddTorrentOptions, BulkRequest, BulkResponse, Torrent, UpdateFileSelectionRequest, UploadTorrentsResponse } from '../types';
 
 export const torrentService = {
   // Get all torrents
@@ -15,14 +15,41 @@ export const torrentService = {
   },
 
   // Add a new torrent
-  addTorrent: async (magnetLink: string) => {
-    const response = await api.post<{ status: string }>('/torrent', { magnet: magnetLink });
+  addTorrent: async (magnetLink: string, options: AddTorrentOptions = {}) => {
+    const response = await api.post<{ status: string }>('/torrent', { magnet: magnetLink, ...options });
     return response.data;
   },
 
-  // Delete a torrent
-  deleteTorrent: async (infoHash: string) => {
-    const response = await api.delete(`/torrent/${infoHash}`);
+  // Add a remote .torrent file, or the magnet it redirects to
+  addTorrentURL: async (url: string, options: AddTorrentOptions = {}) => {
+    const response = await api.post<{ status: string; infoHash: string }>('/torrent', { url, ...options });
+    return response.data;
+  },
+
+  // Upload .torrent files, each is added or reported with its error
+  uploadTorrents: async (files: File[], options: AddTorrentOptions = {}) => {
+    const form = new FormData();
+    files.forEach((file) => form.append('torrents', file));
+    Object.entries(options).forEach(([key, value]) => {
+      (Array.isArray(value) ? value : [value]).forEach((v) => form.append(key, String(v)));
+    });
+    const response = await api.post<UploadTorrentsResponse>('/torrent', form, {
+      headers: { 'Content-Type': 'multipart/form-data' },
+    });
+    return response.data;
+  },
+
+  // Apply an action to many torrents at once
+  bulk: async (request: BulkRequest) => {
+    const response = await api.post<BulkResponse>('/torrents/bulk', request);
+    return response.data;
+  },
+
+  // Delete a torrent, optionally with its downloaded files
+  deleteTorrent: async (infoHash: string, deleteData = false) => {
+    const response = await api.delete(`/torrent/${infoHash}`, {
+      params: deleteData ? { deleteData: true } : undefined,
+    });
     return response.data;
   },
 
@@ -32,6 +32,26 @@ const initialState: ConfigSliceState = {
     EnableUpload: true,
     EnableSeeding: false,
     IncomingPort: 50007,
+    WatchDirectory: '',
+    Blocklist: '',
+    ProxyURL: '',
+    ProxyOnly: false,
+    EncryptionPolicy: '',
+    DisableDHT: false,
+    DisablePEX: false,
+    DisableUTP: false,
+    DisableTCP: false,
+    DisableIPv6: false,
+    DHTBootstrapNodes: null,
+    DisablePortForwarding: false,
+    Storage: '',
+    StreamCacheMB: 0,
+    MinFreeSpaceMB: 0,
+    IncompleteDirectory: '',
+    PartExtension: false,
+    ExtractArchives: false,
+    DeleteArchives: false,
+    BlockPrivateURLs: false,
   },
   loading: false,
   error: null,
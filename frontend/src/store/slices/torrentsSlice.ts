@@ -22,10 +22,10 @@ export const addTorrent = createAsyncThunk<void, string>(
   }
 );
 
-export const deleteTorrent = createAsyncThunk<string, string>(
+export const deleteTorrent = createAsyncThunk<string, { infoHash: string; deleteData?: boolean }>(
   'torrents/deleteTorrent',
-  async (infoHash: string) => {
-    await torrentService.deleteTorrent(infoHash);
+  async ({ infoHash, deleteData = false }) => {
+    await torrentService.deleteTorrent(infoHash, deleteData);
     return infoHash;
   }
 );
@@ -14,14 +14,28 @@ export interface Torrent {
   Name: string;
   Loaded: boolean;
   Downloaded: number;
+  Uploaded: number;
   Size: number;
   Files: TorrentFile[];
   Started: boolean;
   Dropped: boolean;
+  AddedAt: string;
   Percent: number;
+  Ratio: number;
   DownloadRate: number;
   UploadRate: number;
   Peers: number;
+  Stream: boolean;
+  Error: string;
+  SavePath: string;
+  Category: string;
+  Tags: string[] | null;
+  Sequential: boolean;
+  DownloadLimit: number;
+  UploadLimit: number;
+  ExtractStatus: '' | 'extracting' | 'extracted' | 'failed';
+  ExtractPercent: number;
+  ExtractError: string;
 }
 
 // Config types - matching backend engine/config.go
@@ -32,6 +46,26 @@ export interface Config {
   EnableUpload: boolean;
   EnableSeeding: boolean;
   IncomingPort: number;
+  WatchDirectory: string;
+  Blocklist: string;
+  ProxyURL: string;
+  ProxyOnly: boolean;
+  EncryptionPolicy: '' | 'prefer' | 'require' | 'allow' | 'disable';
+  DisableDHT: boolean;
+  DisablePEX: boolean;
+  DisableUTP: boolean;
+  DisableTCP: boolean;
+  DisableIPv6: boolean;
+  DHTBootstrapNodes: string[] | null;
+  DisablePortForwarding: boolean;
+  Storage: '' | 'file' | 'mmap' | 'bolt' | 'sqlite';
+  StreamCacheMB: number;
+  MinFreeSpaceMB: number;
+  IncompleteDirectory: string;
+  PartExtension: boolean;
+  ExtractArchives: boolean;
+  DeleteArchives: boolean;
+  BlockPrivateURLs: boolean;
 }
 
 // File system types - matching backend server/server_files.go
@@ -39,6 +73,7 @@ export interface FileNode {
   Name: string;
   Size: number;
   Modified: string;
+  Partial: boolean;
   Children?: FileNode[];
 }
 
@@ -113,6 +148,55 @@ export interface AddTorrentRequest {
 
 export interface UpdateConfigRequest extends Partial<Config> {}
 
+// Options applied when adding a torrent
+export interface AddTorrentOptions {
+  stream?: boolean;
+  paused?: boolean;
+  savePath?: string;
+  category?: string;
+  tags?: string[];
+  files?: number[]; // indexes of the files to download
+  fileGlobs?: string[]; // patterns matching the files to download
+  sequential?: boolean;
+  downloadLimit?: number; // bytes per second
+  uploadLimit?: number; // bytes per second
+}
+
+export interface UploadTorrentsResponse {
+  added: number;
+  results: { file: string; infoHash?: string; error?: string }[];
+}
+
+export type BulkAction =
+  | 'start'
+  | 'stop'
+  | 'remove'
+  | 'removeData'
+  | 'recheck'
+  | 'setCategory'
+  | 'setLimits';
+
+// Torrents are selected by infohash, or by a filter
+export interface BulkRequest {
+  action: BulkAction;
+  infoHashes?: string[];
+  filter?: { tag?: string; category?: string; state?: 'started' | 'stopped' | 'complete' | 'error'; name?: string };
+  category?: string;
+  downloadLimit?: number;
+  uploadLimit?: number;
+}
+
+export interface BulkResponse {
+  results: { infoHash: string; error?: string; kept?: string[] }[];
+}
+
+// Body of every REST API error
+export interface ApiError {
+  code: string;
+  message: string;
+  details?: unknown;
+}
+
 export interface UpdateFileSelectionRequest {
   filePaths: string[];
   action: 'start' | 'stop';